@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Adaptive broadcast rate limits
+const (
+	MaxBroadcastDivisor = 4     // Lowest rate is the broadcast rate / MaxBroadcastDivisor
+	TickTimeSmoothing   = 0.1   // Weight of the newest tick in the moving average
+	MinSnapshotStep     = 0.004 // Simulated seconds per tick below which motion looks frozen
+)
+
+// Adaptive rate state. broadcastDivisor is guarded by clientsMu, the average
+// is only touched by the physics goroutine.
+var (
+	broadcastDivisor = 1 // Snapshots go out every broadcastDivisor ticks
+	broadcastFloor   = 1 // Lowest divisor, from the broadcast rate and raised by combined broadcasts
+	averageTickTime  time.Duration
+)
+
+// Halve the broadcast rate while ticks run over budget and double it again
+// once they fall below half the budget
+func adjustBroadcastRate(elapsed time.Duration) {
+	if !config.AdaptiveRate {
+		return
+	}
+	averageTickTime += time.Duration(TickTimeSmoothing * float64(elapsed-averageTickTime))
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	switch {
+	case averageTickTime > config.TickBudget && broadcastDivisor < baseDivisor()*MaxBroadcastDivisor:
+		broadcastDivisor *= 2
+		log.Printf("Tick time %v over budget, broadcasting at %d Hz", averageTickTime, TickRate/broadcastDivisor)
+	case averageTickTime < config.TickBudget/2 && broadcastDivisor > broadcastFloor:
+		broadcastDivisor /= 2
+		log.Printf("Tick time %v recovered, broadcasting at %d Hz", averageTickTime, TickRate/broadcastDivisor)
+	}
+}
+
+// Broadcast every few ticks while a small time scale leaves each tick's
+// step under MinSnapshotStep, so every snapshot carries visible motion. The
+// caller must hold clientsMu.
+func combineBroadcasts() {
+	if !config.CombineBroadcasts {
+		return
+	}
+	floor := baseDivisor()
+	for floor < baseDivisor()*MaxBroadcastDivisor && world.timeStep()*float64(floor) < MinSnapshotStep {
+		floor *= 2
+	}
+	if floor == broadcastFloor {
+		return
+	}
+	broadcastFloor = floor
+	if !config.AdaptiveRate || broadcastDivisor < floor {
+		broadcastDivisor = floor
+	}
+	log.Printf("Time step %gs per tick, broadcasting at %d Hz", world.timeStep(), TickRate/broadcastDivisor)
+}
+
+// Ticks between broadcasts at the configured broadcast rate
+func baseDivisor() int {
+	return TickRate / config.BroadcastRate
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// Smallest aggregation cell, so a request cannot ask for a cell per entity
+const MinAggregateCell = 10
+
+// AggregateCell summarizes the live entities in one grid cell
+type AggregateCell struct {
+	X        int     `json:"x"` // Cell column, the cell spans [x, x+1) cell sizes
+	Y        int     `json:"y"`
+	Count    int     `json:"count"`
+	Mass     float64 `json:"mass"`
+	Velocity Vector2 `json:"velocity"` // Mass-weighted mean, the cell's bulk flow
+}
+
+// AggregateMessage is a density and flow field over the whole simulation,
+// for overviews too large to draw entity by entity
+type AggregateMessage struct {
+	Envelope
+	CellSize float64         `json:"cellSize"`
+	Cells    []AggregateCell `json:"cells"` // Occupied cells only, by row then column
+}
+
+// Parse the cell query parameter, defaulting to the spatial grid's cell size
+func aggregateCellSize(r *http.Request) (float64, bool) {
+	s := r.URL.Query().Get("cell")
+	if s == "" {
+		return max(config.GridCellSize, MinAggregateCell), true
+	}
+	size, err := strconv.ParseFloat(s, 64)
+	return size, err == nil && isFinite(size) && size >= MinAggregateCell
+}
+
+// Bucket live entities into cells and sum each cell, the caller must hold
+// clientsMu
+func aggregate(cellSize float64) AggregateMessage {
+	var live []*Entity
+	for _, entity := range bodies() {
+		if entity.alive() {
+			live = append(live, entity)
+		}
+	}
+	msg := AggregateMessage{Envelope: envelope("aggregate"), CellSize: cellSize, Cells: []AggregateCell{}}
+	for key, members := range gridOf(live, cellSize).cells {
+		cell := AggregateCell{X: key[0], Y: key[1], Count: len(members)}
+		for _, m := range members {
+			cell.Mass += m.Mass
+			cell.Velocity.X += m.Mass * m.Velocity.X
+			cell.Velocity.Y += m.Mass * m.Velocity.Y
+		}
+		cell.Velocity.X /= cell.Mass
+		cell.Velocity.Y /= cell.Mass
+		msg.Cells = append(msg.Cells, cell)
+	}
+	sort.Slice(msg.Cells, func(i, j int) bool {
+		if msg.Cells[i].Y != msg.Cells[j].Y {
+			return msg.Cells[i].Y < msg.Cells[j].Y
+		}
+		return msg.Cells[i].X < msg.Cells[j].X
+	})
+	return msg
+}
+
+// Serve the aggregated density and flow field, at most the response cap of
+// cells
+func aggregateHandler(w http.ResponseWriter, r *http.Request) {
+	cellSize, ok := aggregateCellSize(r)
+	if !ok {
+		http.Error(w, "cell must be a number of at least "+strconv.Itoa(MinAggregateCell), http.StatusBadRequest)
+		return
+	}
+
+	clientsMu.Lock()
+	msg := aggregate(cellSize)
+	clientsMu.Unlock()
+
+	cells := msg.Cells[:min(len(msg.Cells), config.MaxResponseEntities)]
+	head := struct {
+		Envelope
+		CellSize float64 `json:"cellSize"`
+	}{msg.Envelope, msg.CellSize}
+	writeList(w, head, "cells", cells)
+}
+
+// Marshal the aggregated field as one event stream frame
+func aggregateFrame(cellSize float64) ([]byte, error) {
+	clientsMu.Lock()
+	msg := aggregate(cellSize)
+	clientsMu.Unlock()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return []byte("event: aggregate\ndata: " + string(data) + "\n\n"), nil
+}
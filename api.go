@@ -0,0 +1,676 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits for the field grid endpoint
+const (
+	DefaultFieldResolution = 32
+	MaxFieldResolution     = 256
+)
+
+// FieldSample is the gravitational acceleration at a grid point
+type FieldSample struct {
+	Position Vector2 `json:"position"`
+	Accel    Vector2 `json:"accel"`
+}
+
+// FieldResponse is returned by the field endpoint, followed by its samples
+type FieldResponse struct {
+	Resolution int     `json:"resolution"`
+	Width      float64 `json:"width"`
+	Height     float64 `json:"height"`
+}
+
+// Write a value as JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logThrottled("JSON error:", err)
+	}
+}
+
+// Write the fields of head as a JSON object with items as its last field,
+// named key, or items alone as an array when head is nil. Items are encoded
+// one by one so a long list is never built in memory as JSON.
+func writeList[T any](w http.ResponseWriter, head any, key string, items []T) {
+	prefix, tail := []byte("["), "]\n"
+	if head != nil {
+		object, err := json.Marshal(head)
+		if err != nil {
+			logThrottled("JSON error:", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		prefix = object[:len(object)-1]
+		if len(prefix) > 1 {
+			prefix = append(prefix, ',')
+		}
+		prefix = append(strconv.AppendQuote(prefix, key), ":["...)
+		tail = "]}\n"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(prefix)
+	enc := json.NewEncoder(w)
+	for i := range items {
+		if i > 0 {
+			w.Write([]byte(","))
+		}
+		if err := enc.Encode(items[i]); err != nil {
+			logThrottled("JSON error:", err)
+			return
+		}
+	}
+	w.Write([]byte(tail))
+}
+
+// Sample the gravitational field on a resolution x resolution grid across
+// the world, with entities=true adding the pull of every entity. Without a
+// resolution the default is lowered to fit the response cap.
+func fieldHandler(w http.ResponseWriter, r *http.Request) {
+	resolution := min(DefaultFieldResolution, max(int(math.Sqrt(float64(config.MaxResponseEntities))), 2))
+	if s := r.URL.Query().Get("resolution"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 2 || n > MaxFieldResolution {
+			http.Error(w, "invalid resolution", http.StatusBadRequest)
+			return
+		}
+		resolution = n
+	}
+	if resolution*resolution > config.MaxResponseEntities {
+		http.Error(w, fmt.Sprintf("resolution gives more than %d samples", config.MaxResponseEntities), http.StatusBadRequest)
+		return
+	}
+
+	samples := make([]FieldSample, 0, resolution*resolution)
+	stepX := WorldWidth / float64(resolution-1)
+	stepY := WorldHeight / float64(resolution-1)
+
+	withEntities := r.URL.Query().Get("entities") == "true"
+
+	clientsMu.Lock()
+	var all []*Entity
+	if withEntities {
+		all = bodies()
+	}
+	for j := 0; j < resolution; j++ {
+		for i := 0; i < resolution; i++ {
+			pos := Vector2{
+				X: -WorldWidth/2 + float64(i)*stepX,
+				Y: -WorldHeight/2 + float64(j)*stepY,
+			}
+			accel := world.gravitationalAccel(pos)
+			if withEntities {
+				_, pull := entityGravity(pos, all)
+				accel.X += pull.X
+				accel.Y += pull.Y
+			}
+			samples = append(samples, FieldSample{Position: pos, Accel: accel})
+		}
+	}
+	clientsMu.Unlock()
+
+	head := FieldResponse{Resolution: resolution, Width: WorldWidth, Height: WorldHeight}
+	writeList(w, head, "samples", samples)
+}
+
+// Wrap a handler so it requires the admin token. Admin endpoints are disabled
+// when no token is configured.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			http.Error(w, "admin API disabled", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ConnectionDebug describes the buffers of one connection
+type ConnectionDebug struct {
+	ID              string `json:"id"`
+	PendingSnapshot bool   `json:"pendingSnapshot"`
+	QueuedEvents    int    `json:"queuedEvents"`
+	QueuedInputs    int    `json:"queuedInputs"`
+}
+
+// DebugResponse is returned by the debug endpoint
+type DebugResponse struct {
+	Goroutines  int               `json:"goroutines"`
+	Clients     int               `json:"clients"`
+	Bots        int               `json:"bots"`
+	Connections []ConnectionDebug `json:"connections"`
+}
+
+// Report goroutine, map and buffer sizes for leak hunting
+func debugHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMu.Lock()
+	resp := DebugResponse{
+		Clients:     len(clients),
+		Bots:        len(bots),
+		Connections: make([]ConnectionDebug, 0, len(clients)),
+	}
+	for client := range clients {
+		client.mu.Lock()
+		resp.Connections = append(resp.Connections, ConnectionDebug{
+			ID:              client.Entity.ID,
+			PendingSnapshot: client.snapshot != nil,
+			QueuedEvents:    len(client.events),
+			QueuedInputs:    len(client.inputs),
+		})
+		client.mu.Unlock()
+	}
+	clientsMu.Unlock()
+	resp.Goroutines = runtime.NumGoroutine()
+
+	writeJSON(w, resp)
+}
+
+// SimulationConfig describes the parameters clients need to render and predict
+type SimulationConfig struct {
+	G                 float64      `json:"g"`
+	StarMass          float64      `json:"starMass"`
+	StarRadius        float64      `json:"starRadius"`
+	GravityMultiplier float64      `json:"gravityMultiplier"`
+	WorldWidth        float64      `json:"worldWidth"`
+	WorldHeight       float64      `json:"worldHeight"`
+	Wrap              bool         `json:"wrap"`
+	TickRate          int          `json:"tickRate"`
+	TimeStep          float64      `json:"timeStep"`
+	TimeScale         float64      `json:"timeScale"`
+	CollisionMode     string       `json:"collisionMode"`
+	Restitution       float64      `json:"restitution"`
+	CollisionScale    float64      `json:"collisionRadiusScale"`
+	FuelCapacity      float64      `json:"fuelCapacity"` // Zero when fuel is unlimited
+	RefuelZones       []RefuelZone `json:"refuelZones"`
+	Planets           []Planet     `json:"planets"`
+	BroadcastRate     int          `json:"broadcastRate"`
+	SafeRadius        float64      `json:"safeRadius"`
+	MaxThrust         float64      `json:"maxThrust"`
+}
+
+// Report the effective simulation parameters
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMu.Lock()
+	multiplier, scale := world.gravityMultiplier, world.timeScale
+	clientsMu.Unlock()
+
+	writeJSON(w, SimulationConfig{
+		G:                 G,
+		StarMass:          starMass(),
+		StarRadius:        currentStar().Radius,
+		GravityMultiplier: multiplier,
+		WorldWidth:        WorldWidth,
+		WorldHeight:       WorldHeight,
+		Wrap:              config.Wrap,
+		TickRate:          TickRate,
+		TimeStep:          TimeStep,
+		TimeScale:         scale,
+		CollisionMode:     collisionMode(),
+		Restitution:       config.Restitution,
+		CollisionScale:    config.CollisionRadiusScale,
+		FuelCapacity:      config.FuelCapacity,
+		RefuelZones:       config.RefuelZones,
+		Planets:           config.Planets,
+		BroadcastRate:     config.BroadcastRate,
+		SafeRadius:        config.SafeRadius,
+		MaxThrust:         MaxThrust,
+	})
+}
+
+// PotentialResponse is returned by the potential endpoint
+type PotentialResponse struct {
+	Position  Vector2 `json:"position"`
+	Potential float64 `json:"potential"`
+	Field     Vector2 `json:"field"`
+}
+
+// Report the gravitational potential and field at a point, from the star and
+// optionally from every entity
+func potentialHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	x, errX := strconv.ParseFloat(query.Get("x"), 64)
+	y, errY := strconv.ParseFloat(query.Get("y"), 64)
+	if errX != nil || errY != nil || !isFinite(x) || !isFinite(y) {
+		http.Error(w, "invalid point", http.StatusBadRequest)
+		return
+	}
+	pos := Vector2{X: x, Y: y}
+
+	clientsMu.Lock()
+	resp := PotentialResponse{
+		Position:  pos,
+		Potential: world.starPotential(pos),
+		Field:     world.gravitationalAccel(pos),
+	}
+	if query.Get("entities") == "true" {
+		phi, accel := entityGravity(pos, bodies())
+		resp.Potential += phi
+		resp.Field.X += accel.X
+		resp.Field.Y += accel.Y
+	}
+	clientsMu.Unlock()
+
+	writeJSON(w, resp)
+}
+
+// Report the system's center of mass and total momentum
+func comHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMu.Lock()
+	summary := massSummary(bodies())
+	clientsMu.Unlock()
+
+	writeJSON(w, summary)
+}
+
+// Report the system's total angular momentum about the star
+func angularMomentumHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMu.Lock()
+	total := angularMomentum(bodies())
+	clientsMu.Unlock()
+
+	writeJSON(w, map[string]float64{"angularMomentum": total})
+}
+
+// Build a handler that freezes or unfreezes an entity in place
+func freezeHandler(frozen bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientsMu.Lock()
+		entity := lookupEntity(r.PathValue("id"))
+		if entity == nil {
+			clientsMu.Unlock()
+			http.Error(w, "entity not found", http.StatusNotFound)
+			return
+		}
+		entity.Static = frozen
+		updated := *entity
+		clientsMu.Unlock()
+
+		writeJSON(w, updated)
+	}
+}
+
+// MassRequest sets an entity's mass, or changes it by a delta
+type MassRequest struct {
+	Mass  *float64 `json:"mass"`
+	Delta *float64 `json:"delta"`
+}
+
+// Set or adjust an entity's mass and recompute its radius
+func massHandler(w http.ResponseWriter, r *http.Request) {
+	var req MassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Mass == nil) == (req.Delta == nil) {
+		http.Error(w, "body must set exactly one of mass or delta", http.StatusBadRequest)
+		return
+	}
+
+	clientsMu.Lock()
+	entity := lookupEntity(r.PathValue("id"))
+	if entity == nil {
+		clientsMu.Unlock()
+		http.Error(w, "entity not found", http.StatusNotFound)
+		return
+	}
+	mass := entity.Mass
+	if req.Mass != nil {
+		mass = *req.Mass
+	} else {
+		mass += *req.Delta
+	}
+	if !isFinite(mass) || mass <= 0 {
+		clientsMu.Unlock()
+		http.Error(w, "mass must be finite and positive", http.StatusBadRequest)
+		return
+	}
+	entity.Mass = mass
+	entity.Radius = entityRadius(mass)
+	entity.burned = 0
+	updated := *entity
+	clientsMu.Unlock()
+
+	writeJSON(w, updated)
+}
+
+// Limits for the neighbor probe
+const (
+	DefaultProbeRadius = 50
+	DefaultProbeSample = 20
+	MaxProbeSample     = 1000
+)
+
+// NeighborCount is the number of entities near a sampled entity
+type NeighborCount struct {
+	ID        string `json:"id"`
+	Neighbors int    `json:"neighbors"`
+}
+
+// Count neighbors within a radius for a sample of entities
+func neighborsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	radius := float64(DefaultProbeRadius)
+	if s := query.Get("r"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || !isFinite(v) || v <= 0 {
+			http.Error(w, "invalid radius", http.StatusBadRequest)
+			return
+		}
+		radius = v
+	}
+	sample := DefaultProbeSample
+	if s := query.Get("sample"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > min(MaxProbeSample, config.MaxResponseEntities) {
+			http.Error(w, "invalid sample", http.StatusBadRequest)
+			return
+		}
+		sample = n
+	}
+
+	clientsMu.Lock()
+	all := bodies()
+	grid := newSpatialGrid(all)
+	counts := make([]NeighborCount, 0, sample)
+	for _, i := range rand.Perm(len(all)) {
+		if len(counts) == sample {
+			break
+		}
+		entity := all[i]
+		n := 0
+		grid.near(entity.Position, radius, all, func(other *Entity, _ float64) {
+			if other != entity {
+				n++
+			}
+		})
+		counts = append(counts, NeighborCount{ID: entity.ID, Neighbors: n})
+	}
+	clientsMu.Unlock()
+
+	writeList(w, nil, "", counts)
+}
+
+// Longest announcement accepted
+const MaxAnnouncementLength = 500
+
+// AnnouncementMessage is an operator message shown to every client
+type AnnouncementMessage struct {
+	Envelope
+	Text string `json:"text"`
+}
+
+// Send an announcement to every connected player and spectator
+func announceHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Text == "" || len(req.Text) > MaxAnnouncementLength {
+		http.Error(w, "invalid announcement", http.StatusBadRequest)
+		return
+	}
+
+	clientsMu.Lock()
+	data, err := json.Marshal(AnnouncementMessage{Envelope: envelope("announcement"), Text: req.Text})
+	if err == nil {
+		for client := range clients {
+			client.enqueue(nil, [][]byte{data})
+		}
+		countMessages("out", "announcement", len(clients))
+	}
+	recipients := len(clients)
+	clientsMu.Unlock()
+	if err != nil {
+		logThrottled("JSON error:", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]int{"recipients": recipients})
+}
+
+// Serve the exact bytes of the latest snapshot broadcast. A snapshot split
+// across frames is served as a JSON array of its pages.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	data := lastSnapshot.Load()
+	if data == nil {
+		http.Error(w, "no snapshot yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	pages := *data
+	if len(pages) == 1 {
+		w.Write(pages[0])
+		return
+	}
+	w.Write([]byte("["))
+	for i, page := range pages {
+		if i > 0 {
+			w.Write([]byte(","))
+		}
+		w.Write(page)
+	}
+	w.Write([]byte("]"))
+}
+
+// StatsResponse breaks connected clients down by role, negotiated format and
+// compression
+type StatsResponse struct {
+	Clients        int               `json:"clients"`
+	Players        int               `json:"players"`
+	Spectators     int               `json:"spectators"`
+	PeakPlayers    int               `json:"peakPlayers"`
+	PeakSpectators int               `json:"peakSpectators"`
+	Protocol       string            `json:"protocol"` // HTTP version serving this request
+	Requests       map[string]uint64 `json:"requests"` // Requests served by HTTP version
+	Formats        map[string]int    `json:"formats"`
+	Compression    map[string]int    `json:"compression"`
+}
+
+// Report how the connected clients negotiated their connections
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := StatsResponse{
+		Protocol:    r.Proto,
+		Requests:    protocolSnapshot(),
+		Formats:     map[string]int{"json": 0, "binary": 0},
+		Compression: map[string]int{"on": 0, "off": 0},
+	}
+	clientsMu.Lock()
+	resp.Clients = len(clients)
+	resp.Players = playerCount()
+	resp.Spectators = resp.Clients - resp.Players
+	resp.PeakPlayers, resp.PeakSpectators = peakPlayers, peakSpectators
+	for client := range clients {
+		if client.binary {
+			resp.Formats["binary"]++
+		} else {
+			resp.Formats["json"]++
+		}
+		if client.compressed {
+			resp.Compression["on"]++
+		} else {
+			resp.Compression["off"]++
+		}
+	}
+	clientsMu.Unlock()
+
+	writeJSON(w, resp)
+}
+
+// PipelineStage is one step applied to entities each tick
+type PipelineStage struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Detail  string `json:"detail,omitempty"`
+
+	// Steps run on each entity within the stage, in order
+	Steps []PipelineStage `json:"steps,omitempty"`
+}
+
+// Report the per-tick physics stages
+func pipelineHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, pipelineStages())
+}
+
+// List entities a page at a time, at most the configured cap per response.
+// Entities are encoded one by one so the response is never built in memory.
+func entitiesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, limit := 0, config.MaxResponseEntities
+	if s := query.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > config.MaxResponseEntities {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	clientsMu.Lock()
+	all := bodies()
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	total := len(all)
+	page := make([]Entity, 0, limit)
+	for i := offset; i < total && len(page) < limit; i++ {
+		page = append(page, *all[i])
+	}
+	clientsMu.Unlock()
+
+	head := struct {
+		Total  int `json:"total"`
+		Offset int `json:"offset"`
+	}{total, offset}
+	writeList(w, head, "entities", page)
+}
+
+// Fastest and slowest time scales accepted
+const (
+	MinTimeScale = 0.1
+	MaxTimeScale = 8.0
+)
+
+// Change how many simulated seconds pass per real second, from the start of
+// the next tick
+func timeScaleHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Scale float64 `json:"scale"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !isFinite(req.Scale) ||
+		req.Scale < MinTimeScale || req.Scale > MaxTimeScale {
+		http.Error(w, fmt.Sprintf("scale must be between %g and %g", MinTimeScale, MaxTimeScale), http.StatusBadRequest)
+		return
+	}
+
+	clientsMu.Lock()
+	stagedTimeScale = req.Scale
+	if !simRunning {
+		// No tick to wait for
+		applyStaged()
+	}
+	clientsMu.Unlock()
+
+	writeJSON(w, map[string]float64{"scale": req.Scale})
+}
+
+// NearEntity is an entity found by the near query with its distance
+type NearEntity struct {
+	Entity
+	Distance float64 `json:"distance"`
+}
+
+// List entities within a radius of a point, nearest first and at most the
+// response entity limit
+func nearHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	x, errX := strconv.ParseFloat(query.Get("x"), 64)
+	y, errY := strconv.ParseFloat(query.Get("y"), 64)
+	if errX != nil || errY != nil || !isFinite(x) || !isFinite(y) {
+		http.Error(w, "invalid point", http.StatusBadRequest)
+		return
+	}
+	radius, err := strconv.ParseFloat(query.Get("r"), 64)
+	if err != nil || !isFinite(radius) || radius <= 0 {
+		http.Error(w, "invalid radius", http.StatusBadRequest)
+		return
+	}
+
+	clientsMu.Lock()
+	all := bodies()
+	found := []NearEntity{}
+	newSpatialGrid(all).near(Vector2{X: x, Y: y}, radius, all, func(entity *Entity, dist float64) {
+		found = append(found, NearEntity{Entity: *entity, Distance: dist})
+	})
+	clientsMu.Unlock()
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Distance != found[j].Distance {
+			return found[i].Distance < found[j].Distance
+		}
+		return found[i].ID < found[j].ID
+	})
+	if len(found) > config.MaxResponseEntities {
+		found = found[:config.MaxResponseEntities]
+	}
+	writeList(w, nil, "", found)
+}
+
+// SOIResponse gives an entity's gravitational reach against the star
+type SOIResponse struct {
+	ID       string  `json:"id"`
+	Distance float64 `json:"distance"` // Orbital distance used, the semi-major axis when bound
+	SOI      float64 `json:"soi"`      // Laplace sphere of influence, a (m/M)^(2/5)
+	Hill     float64 `json:"hill"`     // Hill sphere at periapsis, a (1-e) (m/3M)^(1/3)
+}
+
+// Report an entity's sphere of influence and Hill sphere radii. Unbound
+// entities use their current distance from the star.
+func soiHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMu.Lock()
+	entity := lookupEntity(r.PathValue("id"))
+	if entity == nil {
+		clientsMu.Unlock()
+		http.Error(w, "entity not found", http.StatusNotFound)
+		return
+	}
+	star := starMass() * world.gravityMultiplier
+	if star == 0 {
+		clientsMu.Unlock()
+		http.Error(w, "no star to orbit", http.StatusConflict)
+		return
+	}
+	elements := orbitalElements(*entity)
+	d := displacement(starPosition(), entity.Position)
+	resp := SOIResponse{ID: entity.ID, Distance: math.Hypot(d.X, d.Y)}
+	ratio := entity.Mass / star
+	clientsMu.Unlock()
+
+	periapsis := resp.Distance
+	if elements.Bound {
+		resp.Distance = elements.SemiMajorAxis
+		periapsis = elements.SemiMajorAxis * (1 - elements.Eccentricity)
+	}
+	resp.SOI = resp.Distance * math.Pow(ratio, 0.4)
+	resp.Hill = periapsis * math.Cbrt(ratio/3)
+	writeJSON(w, resp)
+}
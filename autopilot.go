@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"math"
+)
+
+// Autopilot controller parameters
+const (
+	AutopilotThrust       = MaxThrust / 2 // Gentler than full manual thrust
+	AutopilotEccentricity = 0.01          // Orbits this round count as circular
+)
+
+// AutopilotMessage reports autopilot progress to its player
+type AutopilotMessage struct {
+	Envelope
+	Mode   string `json:"mode"`
+	Status string `json:"status"` // "engaged", "complete" or "cancelled"
+}
+
+// Engage an autopilot mode for the player
+func handleAutopilot(client *Client, msg ClientMessage) bool {
+	if msg.Mode != "circularize" {
+		log.Println("Message error: unknown autopilot mode", msg.Mode)
+		return false
+	}
+	if config.NoStar {
+		log.Println("Message error: autopilot needs the star")
+		return false
+	}
+	if config.GravityExponent != 2 {
+		// Completion is judged by eccentricity, which only means something
+		// under inverse-square gravity
+		log.Println("Message error: autopilot needs inverse-square gravity")
+		return false
+	}
+
+	clientsMu.Lock()
+	client.autopilot = msg.Mode
+	client.helm.engaged = false
+	client.send(AutopilotMessage{Envelope: envelope("autopilot"), Mode: msg.Mode, Status: "engaged"})
+	clientsMu.Unlock()
+	return true
+}
+
+// Stop the autopilot and tell the player why, the caller must hold clientsMu
+func (c *Client) endAutopilot(status string) {
+	c.send(AutopilotMessage{Envelope: envelope("autopilot"), Mode: c.autopilot, Status: status})
+	c.autopilot = ""
+	c.thrustTarget = Vector2{}
+}
+
+// Steer toward a circular orbit at the current radius, in the current
+// direction of travel, by thrusting along the velocity error. The caller must
+// hold clientsMu.
+func (c *Client) steerAutopilot() {
+	entity := &c.Entity
+	if orbitalElements(*entity).Eccentricity < AutopilotEccentricity {
+		c.endAutopilot("complete")
+		return
+	}
+
+	d := displacement(starPosition(), entity.Position)
+	r := math.Max(math.Hypot(d.X, d.Y), 0.1) // Prevent division by zero
+	speed := calculateOrbitalVelocity(starMass()*world.gravityMultiplier, r)
+	// Tangent in the sense of the current angular momentum
+	sense := 1.0
+	if d.X*entity.Velocity.Y-d.Y*entity.Velocity.X < 0 {
+		sense = -1
+	}
+	target := Vector2{X: -d.Y / r * speed * sense, Y: d.X / r * speed * sense}
+
+	dv := Vector2{X: target.X - entity.Velocity.X, Y: target.Y - entity.Velocity.Y}
+	c.thrustTarget = clampMagnitude(Vector2{X: dv.X / world.timeStep(), Y: dv.Y / world.timeStep()}, AutopilotThrust)
+}
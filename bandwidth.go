@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Token bucket limiting outbound snapshot bytes, guarded by clientsMu
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Outbound snapshot budget, charged from the fan-out workers
+var (
+	egress   tokenBucket
+	egressMu sync.Mutex
+)
+
+// Refill the bucket at rate tokens per second up to one second of burst
+func (b *tokenBucket) refill(now time.Time, rate float64) {
+	b.refillTo(now, rate, rate)
+}
+
+// Refill the bucket at rate tokens per second up to burst tokens, starting full
+func (b *tokenBucket) refillTo(now time.Time, rate, burst float64) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+// Take n tokens if available, reporting whether they were taken
+func (b *tokenBucket) take(n float64) bool {
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// Refill the outbound budget for a broadcast
+func refillEgress(now time.Time) {
+	if config.BandwidthLimit <= 0 {
+		return
+	}
+	egressMu.Lock()
+	defer egressMu.Unlock()
+	egress.refill(now, float64(config.BandwidthLimit))
+}
+
+// Report whether a client's snapshot of size bytes fits the outbound budget.
+// Snapshots over budget are skipped for that client, lowering its effective
+// broadcast rate, while events and deltas always go out.
+func egressFits(size int) bool {
+	if config.BandwidthLimit <= 0 {
+		return true
+	}
+	egressMu.Lock()
+	defer egressMu.Unlock()
+	return egress.tokens >= float64(size)
+}
+
+// Charge the bytes queued for one client to the outbound budget
+func chargeEgress(size int) {
+	if config.BandwidthLimit <= 0 {
+		return
+	}
+	egressMu.Lock()
+	defer egressMu.Unlock()
+	egress.tokens -= float64(size)
+}
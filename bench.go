@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchWorld is an extra world of bots simulated on its own loop and under
+// its own lock, with no clients and no broadcasts, to measure how the server
+// scales with worlds
+type benchWorld struct {
+	*World
+	busy time.Duration // Time spent ticking, guarded by the world's lock
+}
+
+// Benchmark worlds and when they started, written once at startup
+var (
+	benchWorlds []*benchWorld
+	benchStart  time.Time
+)
+
+// physicsOverrides is a repeatable flag.Value holding one benchmark world's
+// physics per use, as comma-separated key=value pairs over the main world's.
+// Only benchmark worlds take overrides: players all join the main world, so
+// per-room parameter sets for game modes wait on rooms players can join.
+type physicsOverrides []string
+
+func (p *physicsOverrides) String() string {
+	return strings.Join(*p, " ")
+}
+
+func (p *physicsOverrides) Set(s string) error {
+	if err := overridePhysics(&Physics{}, s); err != nil {
+		return err
+	}
+	*p = append(*p, s)
+	return nil
+}
+
+// Apply comma-separated key=value overrides to a set of physics parameters,
+// the keys named after the main world's flags
+func overridePhysics(p *Physics, spec string) error {
+	for _, pair := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return fmt.Errorf("physics override %q is not key=value", pair)
+		}
+		if key == "nbody" {
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("physics override %q is not true or false", pair)
+			}
+			p.NBody = on
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || !isFinite(v) {
+			return fmt.Errorf("physics override %q has an invalid number", pair)
+		}
+		if v < 0 {
+			return fmt.Errorf("physics override %q must not be negative", pair)
+		}
+		switch key {
+		case "gravity":
+			if v == 0 {
+				return fmt.Errorf("physics override %q needs a positive gravity", pair)
+			}
+			p.Gravity = v
+		case "time-scale":
+			if v < MinTimeScale || v > MaxTimeScale {
+				return fmt.Errorf("physics override %q needs a time scale between %g and %g", pair, MinTimeScale, MaxTimeScale)
+			}
+			p.TimeScale = v
+		case "restitution":
+			if v > 1 {
+				return fmt.Errorf("physics override %q needs a restitution in [0, 1]", pair)
+			}
+			p.Restitution = v
+		case "softening":
+			p.Softening = v
+		case "gravity-cutoff":
+			p.GravityCutoff = v
+		case "collision-slop":
+			p.CollisionSlop = v
+		case "collision-iterations":
+			if v < 1 || v != float64(int(v)) {
+				return fmt.Errorf("physics override %q needs a whole number of at least 1", pair)
+			}
+			p.CollisionIterations = int(v)
+		default:
+			return fmt.Errorf("physics override %q has an unknown key", pair)
+		}
+	}
+	return nil
+}
+
+// BenchWorldStats is one benchmark world's progress
+type BenchWorldStats struct {
+	Physics     string  `json:"physics,omitempty"` // Overrides of the main world's physics
+	Entities    int     `json:"entities"`
+	Ticks       uint64  `json:"ticks"`
+	AverageTick float64 `json:"averageTickMs"`
+}
+
+// BenchStats aggregates every benchmark world with the process's footprint
+type BenchStats struct {
+	Worlds         int               `json:"worlds"`
+	Entities       int               `json:"entities"`
+	Ticks          uint64            `json:"ticks"`
+	TicksPerSecond float64           `json:"ticksPerSecond"` // Across all worlds
+	AverageTick    float64           `json:"averageTickMs"`
+	Goroutines     int               `json:"goroutines"`
+	HeapBytes      uint64            `json:"heapBytes"`
+	PerWorld       []BenchWorldStats `json:"perWorld"`
+}
+
+// Spawn the configured benchmark worlds and start their tick loops
+func startBenchWorlds() {
+	if config.BenchWorlds == 0 {
+		return
+	}
+	for w := 0; w < config.BenchWorlds; w++ {
+		physics := config.Physics
+		if w < len(config.BenchPhysics) {
+			// Validated when the flag was parsed
+			overridePhysics(&physics, config.BenchPhysics[w])
+		}
+		bench := &benchWorld{World: newWorld(&sync.Mutex{}, &physics)}
+		bench.mu.Lock()
+		placed := make([]Entity, 0, config.BenchBots)
+		for i := 0; i < config.BenchBots; i++ {
+			placed = append(placed, bench.placeEntity(fmt.Sprintf("bench-%d-%d", w, i), randomBotMass(), placed))
+		}
+		for i := range placed {
+			bench.entities = append(bench.entities, &placed[i])
+		}
+		bench.mu.Unlock()
+		benchWorlds = append(benchWorlds, bench)
+	}
+
+	benchStart = time.Now()
+	for _, bench := range benchWorlds {
+		go bench.loop(bench.timedAdvance, func(time.Time) bool { return false })
+	}
+	log.Printf("Benchmark: running %d worlds of %d bots", config.BenchWorlds, config.BenchBots)
+}
+
+// Advance the world one physics step and time it. Each world ticks under
+// its own lock, so the worlds run in parallel with each other and with the
+// main world. The world has no event hook, no client watches it.
+func (b *benchWorld) timedAdvance(time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	start := time.Now()
+	b.advance()
+	b.busy += time.Since(start)
+}
+
+// Report progress across the benchmark worlds
+func benchHandler(w http.ResponseWriter, r *http.Request) {
+	if len(benchWorlds) == 0 {
+		http.Error(w, "no benchmark worlds, see -bench-worlds", http.StatusNotFound)
+		return
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := BenchStats{
+		Worlds:     len(benchWorlds),
+		Goroutines: runtime.NumGoroutine(),
+		HeapBytes:  mem.HeapAlloc,
+	}
+
+	var busy time.Duration
+	for i, bench := range benchWorlds {
+		bench.mu.Lock()
+		ws := BenchWorldStats{Entities: len(bench.entities), Ticks: bench.tick}
+		if i < len(config.BenchPhysics) {
+			ws.Physics = config.BenchPhysics[i]
+		}
+		if bench.tick > 0 {
+			ws.AverageTick = float64(bench.busy) / float64(bench.tick) / float64(time.Millisecond)
+		}
+		busy += bench.busy
+		bench.mu.Unlock()
+		stats.PerWorld = append(stats.PerWorld, ws)
+		stats.Entities += ws.Entities
+		stats.Ticks += ws.Ticks
+	}
+
+	stats.TicksPerSecond = float64(stats.Ticks) / time.Since(benchStart).Seconds()
+	if stats.Ticks > 0 {
+		stats.AverageTick = float64(busy) / float64(stats.Ticks) / float64(time.Millisecond)
+	}
+	writeJSON(w, stats)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Limits for bot creation
+const MaxBotsPerRequest = 100
+
+// BotRequest is the body accepted by the bots endpoint
+type BotRequest struct {
+	Count int     `json:"count"`
+	TTL   float64 `json:"ttl"` // Seconds to live, 0 means forever
+}
+
+// Bots are server-owned entities keyed by ID, guarded by clientsMu
+var bots = make(map[string]*Entity)
+
+// Pick a bot mass from the configured range
+func randomBotMass() float64 {
+	return config.BotMassMin + rand.Float64()*(config.BotMassMax-config.BotMassMin)
+}
+
+// Draw a bot's velocity from the configured distribution, given its spawn
+// orbit's velocity. Maxwell draws each component from a normal distribution
+// with standard deviation spread times circular speed over √2, so the RMS
+// speed is spread times circular, with no net rotation. The caller must hold
+// clientsMu.
+func botVelocity(pos, orbit Vector2) Vector2 {
+	if config.BotVelocity == "rest" {
+		return Vector2{}
+	}
+	d := displacement(starPosition(), pos)
+	r := math.Hypot(d.X, d.Y)
+	if r == 0 || config.NoStar {
+		return orbit
+	}
+	scale := config.BotVelocitySpread * calculateOrbitalVelocity(starMass()*world.gravityMultiplier, r)
+	switch config.BotVelocity {
+	case "radial":
+		return Vector2{X: -d.X / r * scale, Y: -d.Y / r * scale}
+	case "maxwell":
+		sigma := scale / math.Sqrt2
+		return Vector2{X: rand.NormFloat64() * sigma, Y: rand.NormFloat64() * sigma}
+	}
+	return orbit
+}
+
+// Look up a player or bot by ID, the caller must hold clientsMu
+func findEntity(id string) (Entity, bool) {
+	if entity := lookupEntity(id); entity != nil {
+		return *entity, true
+	}
+	return Entity{}, false
+}
+
+// Find the live entity with an ID, or nil. The caller must hold clientsMu.
+func lookupEntity(id string) *Entity {
+	for client := range clients {
+		if !client.Spectator && client.Entity.ID == id {
+			return &client.Entity
+		}
+	}
+	return bots[id]
+}
+
+// Remove expired bots, the caller must hold clientsMu
+func pruneBots(now time.Time) {
+	for id, bot := range bots {
+		if !bot.ExpiresAt.IsZero() && now.After(bot.ExpiresAt) {
+			delete(bots, id)
+			emitEvent(LeaveEvent{Envelope: envelope("leave"), ID: id})
+		}
+	}
+}
+
+// Spawn bots on circular orbits
+func botsHandler(w http.ResponseWriter, r *http.Request) {
+	req := BotRequest{Count: 1}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Count < 1 || req.Count > MaxBotsPerRequest || req.TTL < 0 {
+		http.Error(w, "invalid bot request", http.StatusBadRequest)
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL * float64(time.Second))
+	}
+
+	clientsMu.Lock()
+	created := addBots(req.Count, ttl)
+	clientsMu.Unlock()
+	if len(created) == 0 {
+		http.Error(w, "bot limit reached", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, created)
+}
+
+// Create up to count bots on fresh orbits, the caller must hold clientsMu
+func addBots(count int, ttl time.Duration) []Entity {
+	now := time.Now()
+	spawned := make([]Entity, 0, count)
+	for i := 0; i < count; i++ {
+		bot := world.placeEntity(fmt.Sprintf("bot-%d-%d", now.UnixNano(), i), randomBotMass(), spawned)
+		bot.Velocity = botVelocity(bot.Position, bot.Velocity)
+		if ttl > 0 {
+			bot.ExpiresAt = now.Add(ttl)
+		}
+		spawned = append(spawned, bot)
+	}
+	return insertBots(spawned)
+}
+
+// Add bots to the simulation, truncated to the server-wide bot cap. Every bot
+// creation path goes through here. The caller must hold clientsMu.
+func insertBots(spawned []Entity) []Entity {
+	if config.MaxBots > 0 && len(spawned) > config.MaxBots-len(bots) {
+		spawned = spawned[:max(config.MaxBots-len(bots), 0)]
+	}
+	if len(spawned) == 0 {
+		return nil
+	}
+
+	for i := range spawned {
+		bot := spawned[i]
+		bots[bot.ID] = &bot
+	}
+	startSimulation()
+	return spawned
+}
@@ -0,0 +1,86 @@
+package main
+
+import "encoding/json"
+
+// Radius around an entity counted as its neighborhood in density mode
+const CameraDensityRadius = 100
+
+// CameraMessage recommends where a hands-free spectator view should center
+type CameraMessage struct {
+	Envelope
+	Center Vector2 `json:"center"`
+	Target string  `json:"target,omitempty"` // Entity followed, if any
+}
+
+// Pick a camera center, following the most massive live entity or, in density
+// mode, the mass-weighted center of the most crowded neighborhood. The caller
+// must hold clientsMu.
+func cameraHint(all []*Entity) (CameraMessage, bool) {
+	var best *Entity
+	bestScore := 0.0
+	grid := newSpatialGrid(all)
+	for _, entity := range all {
+		if !entity.alive() {
+			continue
+		}
+		score := entity.Mass
+		if config.CameraMode == "density" {
+			score = 0
+			grid.near(entity.Position, CameraDensityRadius, all, func(other *Entity, _ float64) {
+				if other.alive() {
+					score += other.Mass
+				}
+			})
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = entity, score
+		}
+	}
+	if best == nil {
+		return CameraMessage{}, false
+	}
+
+	hint := CameraMessage{Envelope: envelope("camera"), Center: best.Position, Target: best.ID}
+	if config.CameraMode == "density" {
+		// Average displacements rather than positions so wrapping is respected
+		var offset Vector2
+		grid.near(best.Position, CameraDensityRadius, all, func(other *Entity, _ float64) {
+			if other.alive() {
+				d := displacement(best.Position, other.Position)
+				offset.X += other.Mass * d.X / bestScore
+				offset.Y += other.Mass * d.Y / bestScore
+			}
+		})
+		hint.Center = Vector2{X: best.Position.X + offset.X, Y: best.Position.Y + offset.Y}
+		if config.Wrap {
+			hint.Center = wrapPosition(hint.Center)
+		}
+		hint.Target = ""
+	}
+	return hint, true
+}
+
+// Serialize the camera hint for spectators, or nil when there are none. The
+// caller must hold clientsMu.
+func cameraFrame() []byte {
+	spectators := 0
+	for client := range clients {
+		if client.Spectator {
+			spectators++
+		}
+	}
+	if spectators == 0 {
+		return nil
+	}
+	hint, ok := cameraHint(bodies())
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(hint)
+	if err != nil {
+		logThrottled("JSON error:", err)
+		return nil
+	}
+	countMessages("out", "camera", spectators)
+	return data
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Connection limits
+const (
+	MaxQueuedEvents = 256              // Events queued for a client before the oldest are dropped
+	MaxMessageSize  = 4096             // Largest message accepted from a client
+	PongWait        = 60 * time.Second // Time allowed between pongs from the client
+	PingPeriod      = PongWait * 9 / 10
+	ErrorDecay      = 10 * time.Second // Time constant over which protocol errors are forgiven
+)
+
+// connWriter is the part of a websocket connection the writer uses, so a fake
+// can stand in for *websocket.Conn
+type connWriter interface {
+	WriteMessage(messageType int, data []byte) error
+	SetWriteDeadline(t time.Time) error
+	EnableWriteCompression(enable bool)
+	Close() error
+}
+
+// Client is a connected websocket player
+type Client struct {
+	conn       connWriter
+	binary     bool // Negotiated the binary subprotocol
+	compressed bool // Negotiated permessage-deflate, set at upgrade
+	Entity     Entity
+	inputs     []ThrustInput // Buffered inputs in timestamp order, guarded by clientsMu
+	token      string        // Secret profile token from the cookie, "" without a profile, never broadcast
+
+	// Watching without an entity in the simulation, guarded by clientsMu
+	Spectator bool
+	predict   bool      // Wants trajectory previews while thrusting, guarded by clientsMu
+	lastInput time.Time // Last message handled successfully, or the connect time, guarded by clientsMu
+
+	lastThrustFx time.Time // Last exhaust event, guarded by clientsMu
+	loggedThrust Vector2   // Applied thrust last written to the input log, guarded by clientsMu
+	thrustTarget Vector2   // Requested thrust the applied thrust eases toward, guarded by clientsMu
+	thrustOnce   bool      // thrustTarget resets next tick, for the untyped thrust form, guarded by clientsMu
+	autopilot    string    // Engaged autopilot mode or "", guarded by clientsMu
+	helm         helm      // Ship controls from input messages, guarded by clientsMu
+	impulse      Vector2   // Velocity change due next tick, guarded by clientsMu
+	lastImpulse  time.Time // Last accepted impulse, guarded by clientsMu
+	lastFire     time.Time // Last projectile fired, guarded by clientsMu
+	lastWell     time.Time // Last gravity well placed, guarded by clientsMu
+
+	// Whether the first join was processed and when the name last changed,
+	// guarded by clientsMu
+	joined      bool
+	lastRename  time.Time
+	batchEvents bool // Wants events inside the snapshot frame, guarded by clientsMu
+
+	// Wants field-level deltas instead of snapshots, and the entities as last
+	// sent in one. Guarded by clientsMu.
+	deltas bool
+	sent   map[string]Entity
+
+	// Subscribed snapshot rate in ticks between snapshots, 0 or 1 for every
+	// broadcast, and the tick the next one is due. Guarded by clientsMu.
+	snapshotEvery uint64
+	nextSnapshot  uint64
+
+	mu       sync.Mutex
+	snapshot [][]byte      // Newest snapshot's frames, replaced every tick
+	events   [][]byte      // Events in order, only dropped on overflow
+	wake     chan struct{} // Signals the writer that frames are waiting
+	closing  chan []byte   // Close frame payload, ends the writer once sent
+	done     chan struct{} // Closed when the connection is torn down
+	stop     sync.Once     // Guards teardown, which both goroutines may start
+	slow     atomic.Bool   // A write timed out, its disconnect is already counted
+
+	// Protocol errors charged against the error budget, decaying since
+	// errorAt. Touched only by the read loop.
+	errorLevel float64
+	errorAt    time.Time
+}
+
+// Create a client for a connection
+func newClient(conn connWriter, binary bool, entity Entity) *Client {
+	return &Client{
+		conn:    conn,
+		binary:  binary,
+		Entity:  entity,
+		wake:    make(chan struct{}, 1),
+		closing: make(chan []byte, 1),
+		done:    make(chan struct{}),
+		// Connecting counts as activity, the idle timeout runs from here
+		lastInput: time.Now(),
+	}
+}
+
+// Report whether the upgrade request offered permessage-deflate, which the
+// upgrader accepts whenever compression is enabled
+func offersCompression(r *http.Request) bool {
+	for _, ext := range r.Header.Values("Sec-WebSocket-Extensions") {
+		if strings.Contains(ext, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// Replace the pending snapshot and queue events without blocking. A slow
+// client skips stale snapshots and always receives the newest one.
+func (c *Client) enqueue(snapshot [][]byte, events [][]byte) {
+	c.mu.Lock()
+	if snapshot != nil {
+		c.snapshot = snapshot
+	}
+	c.events = append(c.events, events...)
+	if n := len(c.events); n > MaxQueuedEvents {
+		c.events = c.events[n-MaxQueuedEvents:]
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Report whether the client's subscribed rate wants a snapshot this tick,
+// advancing its schedule when it does. The caller must hold clientsMu.
+func (c *Client) wantsSnapshot() bool {
+	if c.snapshotEvery <= 1 {
+		return true
+	}
+	if world.tick < c.nextSnapshot {
+		return false
+	}
+	c.nextSnapshot = world.tick + c.snapshotEvery
+	return true
+}
+
+// Serialize a message and queue it for this client alone
+func (c *Client) send(msg Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logThrottled("JSON error:", err)
+		return
+	}
+	countMessages("out", msg.messageType(), 1)
+	c.enqueue(nil, [][]byte{data})
+}
+
+// Take everything waiting to be written
+func (c *Client) drain() ([][]byte, [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot, events := c.snapshot, c.events
+	c.snapshot, c.events = nil, nil
+	return snapshot, events
+}
+
+// Unregister the client and close its connection. Safe to call from both the
+// read and write goroutines, only the first call has any effect.
+func (c *Client) shutdown() {
+	c.stop.Do(func() {
+		clientsMu.Lock()
+		removeClient(c)
+		clientsMu.Unlock()
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// Close codes sent by the server
+const (
+	CloseIdle      = 4000
+	CloseHandshake = 4001
+	CloseErrors    = 4002
+)
+
+// Disconnect players that have sent nothing for the idle timeout. Spectators
+// and bots are exempt.
+func idleSweeper() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		clientsMu.Lock()
+		for client := range clients {
+			if !client.Spectator && now.Sub(client.lastInput) > config.IdleTimeout {
+				client.requestClose(CloseIdle, "idle timeout")
+			}
+		}
+		clientsMu.Unlock()
+	}
+}
+
+// Charge a protocol error against the connection's budget, closing it once
+// the decayed error count passes the budget. Occasional malformed messages
+// are forgiven over ErrorDecay, a client that keeps sending them is shed.
+// Transport errors leave nothing to retry on and still close at once.
+func (c *Client) chargeError() {
+	if config.ErrorBudget <= 0 {
+		return
+	}
+	now := time.Now()
+	c.errorLevel = c.errorLevel*math.Exp(-now.Sub(c.errorAt).Seconds()/ErrorDecay.Seconds()) + 1
+	c.errorAt = now
+	budget := float64(config.ErrorBudget)
+	if c.errorLevel <= budget {
+		return
+	}
+	if c.errorLevel-1 <= budget {
+		clientsMu.Lock()
+		id := c.Entity.ID
+		clientsMu.Unlock()
+		log.Printf("Client %s disconnected: error budget of %d exceeded", id, config.ErrorBudget)
+	}
+	c.requestClose(CloseErrors, "error budget exceeded")
+}
+
+// Ask the writer to send a close frame and shut the connection
+func (c *Client) requestClose(code int, reason string) {
+	select {
+	case c.closing <- websocket.FormatCloseMessage(code, reason):
+	default:
+	}
+}
+
+// Write a single frame with the write deadline applied. Frames smaller than
+// the compression threshold are sent uncompressed, since deflating them costs
+// more CPU than it saves bandwidth.
+func (c *Client) write(messageType int, data []byte) error {
+	c.conn.EnableWriteCompression(config.Compression && len(data) >= config.CompressionThreshold)
+	c.conn.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// delayedFrame is an outbound frame held back by simulated latency
+type delayedFrame struct {
+	at   time.Time
+	data []byte
+}
+
+// Simulated one-way latency for a frame, testing only
+func simulatedLatency() time.Duration {
+	d := config.TestLatency
+	if config.TestJitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * float64(config.TestJitter))
+	}
+	return max(d, 0)
+}
+
+// Write frames in order, shutting the connection on the first failure.
+// Reports whether the connection is still usable.
+func (c *Client) writeFrames(frames [][]byte) bool {
+	for _, frame := range frames {
+		if err := c.write(websocket.TextMessage, frame); err != nil {
+			// Stop broadcasting to the client at once, closing the
+			// connection also ends the read loop
+			c.writeFailed(err)
+			return false
+		}
+	}
+	return true
+}
+
+// Log a failed write and shut the connection. A write that hit its deadline
+// means the client stopped reading and its receive window filled, which is
+// counted as a slow reader rather than a network error.
+func (c *Client) writeFailed(err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		clientsMu.Lock()
+		id := c.Entity.ID
+		clientsMu.Unlock()
+		log.Printf("Client %s disconnected as a slow reader: %v", id, err)
+		c.slow.Store(true)
+		countClose("slow_reader")
+	} else {
+		logThrottled("Write error:", err)
+	}
+	c.shutdown()
+}
+
+// Own every write to the connection (frames, pings and the close frame) until
+// it is closed. gorilla/websocket allows only one concurrent writer.
+func (c *Client) writePump() {
+	ping := time.NewTicker(PingPeriod)
+	defer ping.Stop()
+
+	// Frames held back by simulated latency, in send order
+	var delayed []delayedFrame
+	var release <-chan time.Time
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case payload := <-c.closing:
+			// Frames already queued, such as a shutdown notice, go out
+			// before the close frame, held back ones included
+			frames := make([][]byte, 0, len(delayed))
+			for _, frame := range delayed {
+				frames = append(frames, frame.data)
+			}
+			snapshot, events := c.drain()
+			if !c.writeFrames(append(append(frames, snapshot...), events...)) {
+				return
+			}
+			if err := c.write(websocket.CloseMessage, payload); err != nil {
+				logThrottled("Write error:", err)
+			}
+			c.shutdown()
+			return
+		case <-ping.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				c.writeFailed(err)
+				return
+			}
+		case <-c.wake:
+			snapshot, events := c.drain()
+			if snapshot != nil {
+				// Snapshot frames are shared between clients, never append in place
+				events = append(snapshot[:len(snapshot):len(snapshot)], events...)
+			}
+			if config.TestLatency == 0 && config.TestJitter == 0 {
+				if !c.writeFrames(events) {
+					return
+				}
+				continue
+			}
+			// Jitter never reorders frames, a frame waits for the one before it
+			now := time.Now()
+			for _, frame := range events {
+				at := now.Add(simulatedLatency())
+				if n := len(delayed); n > 0 && at.Before(delayed[n-1].at) {
+					at = delayed[n-1].at
+				}
+				delayed = append(delayed, delayedFrame{at: at, data: frame})
+			}
+			if len(delayed) > 0 {
+				release = time.After(time.Until(delayed[0].at))
+			}
+		case now := <-release:
+			due := 0
+			for due < len(delayed) && !delayed[due].at.After(now) {
+				due++
+			}
+			frames := make([][]byte, due)
+			for i := range frames {
+				frames[i] = delayed[i].data
+			}
+			delayed = delayed[due:]
+			if !c.writeFrames(frames) {
+				return
+			}
+			release = nil
+			if len(delayed) > 0 {
+				release = time.After(time.Until(delayed[0].at))
+			}
+		}
+	}
+}
+
+// Wait for the first valid message of a new connection, closing it when none
+// arrives within the handshake timeout. Malformed messages do not count. The
+// message is nil when the timeout is disabled.
+func awaitHandshake(conn *websocket.Conn) (*ClientMessage, bool) {
+	if config.HandshakeTimeout <= 0 {
+		return nil, true
+	}
+	binary := conn.Subprotocol() == BinarySubprotocol
+	conn.SetReadLimit(MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(config.HandshakeTimeout))
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			logThrottled("Handshake error:", err)
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(CloseHandshake, "handshake timeout"),
+				time.Now().Add(config.WriteTimeout))
+			conn.Close()
+			return nil, false
+		}
+		msg, err := decodeMessage(binary, messageType, data)
+		if err != nil {
+			logThrottled("Message error:", err)
+			continue
+		}
+		return &msg, true
+	}
+}
+
+// Read and route messages until the connection fails or closes
+func (c *Client) readPump(conn *websocket.Conn) {
+	conn.SetReadLimit(MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(PongWait))
+	})
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			logDisconnect(c, err)
+			return
+		}
+		handleMessage(c, messageType, data)
+	}
+}
+
+// Log why a connection's read loop ended and count it by close code
+func logDisconnect(c *Client, err error) {
+	if c.slow.Load() {
+		return
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		logThrottled("Read error:", err)
+		countClose("error")
+		return
+	}
+	clientsMu.Lock()
+	id := c.Entity.ID
+	clientsMu.Unlock()
+	switch closeErr.Code {
+	case websocket.CloseNormalClosure, websocket.CloseGoingAway:
+		log.Printf("Client %s closed: %d %s", id, closeErr.Code, closeErr.Text)
+	default:
+		log.Printf("Client %s closed abnormally: %d %s", id, closeErr.Code, closeErr.Text)
+	}
+	countClose(strconv.Itoa(closeErr.Code))
+}
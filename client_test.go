@@ -0,0 +1,76 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Report whether an entity with the ID is being simulated
+func simulated(id string) bool {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for _, e := range bodies() {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDisconnectCleansUp(t *testing.T) {
+	_, watcher := connectFake(t, "fake-watcher")
+	baseline := runtime.NumGoroutine()
+
+	conn := &fakeConn{}
+	client := newClient(conn, false, world.newEntity("fake-leaver", config.PlayerMass))
+	client.token = "fake-token"
+	clientsMu.Lock()
+	profiles[client.token] = Profile{ID: client.Entity.ID}
+	admitClient(client)
+	clientsMu.Unlock()
+	go client.writePump()
+	t.Cleanup(func() {
+		clientsMu.Lock()
+		delete(profiles, "fake-token")
+		clientsMu.Unlock()
+	})
+
+	if !simulated("fake-leaver") {
+		t.Fatal("connected entity is not simulated")
+	}
+
+	client.shutdown()
+
+	clientsMu.Lock()
+	_, registered := clients[client]
+	clientsMu.Unlock()
+	if registered {
+		t.Error("client still registered after disconnect")
+	}
+	if simulated("fake-leaver") {
+		t.Error("entity still simulated after disconnect")
+	}
+	conn.mu.Lock()
+	closed := conn.closed
+	conn.mu.Unlock()
+	if !closed {
+		t.Error("connection not closed")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Errorf("%d goroutines after disconnect, want %d", n, baseline)
+	}
+
+	// The leave goes out at once even though the profile is kept for a resume
+	var leave LeaveEvent
+	runTick(time.Now(), false)
+	watcher.await(t, "leave", &leave)
+	if leave.ID != "fake-leaver" {
+		t.Errorf("leave for %q, want fake-leaver", leave.ID)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Radius of an entity with unit mass
+const EntityRadius = 5
+
+// CollisionEvent is sent when two entities collide
+type CollisionEvent struct {
+	Envelope
+	A     string  `json:"a"`
+	B     string  `json:"b"`
+	Point Vector2 `json:"point"`
+}
+
+// Record that two entities touch this tick and report whether it is a new
+// collision, rather than continued contact within the cooldown. Contacts are
+// only kept while a collision cooldown is configured.
+func (w *World) newContact(a, b *Entity) bool {
+	if config.CollisionCooldown <= 0 {
+		return true
+	}
+	key := [2]string{a.ID, b.ID}
+	last, seen := w.contacts[key]
+	w.contacts[key] = w.tick
+	return !seen || w.tick-last > cooldownTicks()
+}
+
+// Collision cooldown in ticks
+func cooldownTicks() uint64 {
+	return uint64(config.CollisionCooldown.Seconds() * TickRate)
+}
+
+// Forget pairs that have been apart for longer than the cooldown
+func (w *World) pruneContacts() {
+	for key, last := range w.contacts {
+		if w.tick-last > cooldownTicks() {
+			delete(w.contacts, key)
+		}
+	}
+}
+
+// Radius of an entity, scaled so its area is proportional to its mass
+func entityRadius(mass float64) float64 {
+	return EntityRadius * math.Sqrt(mass)
+}
+
+// Radius of an entity's collision hitbox, which may differ from its
+// rendered radius
+func hitRadius(e *Entity) float64 {
+	return e.Radius * config.CollisionRadiusScale
+}
+
+// Name of the collision response for clients
+func collisionMode() string {
+	if config.Physics.Restitution == 1 {
+		return "elastic"
+	}
+	return "inelastic"
+}
+
+// Collect every simulated entity, the caller must hold clientsMu
+func bodies() []*Entity {
+	all := make([]*Entity, 0, len(clients)+len(bots)+len(projectiles))
+	for client := range clients {
+		if !client.Spectator {
+			all = append(all, &client.Entity)
+		}
+	}
+	for _, bot := range bots {
+		all = append(all, bot)
+	}
+	for _, projectile := range projectiles {
+		all = append(all, projectile)
+	}
+	return all
+}
+
+// Bounce overlapping entities apart and emit collision events,
+// the caller must hold w.mu. Dense clusters get repeated passes, since
+// separating one pair can push it into another, but each colliding pair is
+// reported once per tick. Pairs resolve in entity ID order rather than map
+// order, so three-way collisions come out the same on every run.
+func (w *World) resolveCollisions(all []*Entity) {
+	all = append([]*Entity(nil), all...)
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	index := make(map[*Entity]int, len(all))
+	maxRadius := 0.0
+	for i, entity := range all {
+		index[entity] = i
+		maxRadius = math.Max(maxRadius, boundRadius(entity))
+	}
+
+	reported := make(map[[2]int]bool)
+	for pass := 0; pass < w.physics.CollisionIterations; pass++ {
+		deepest := 0.0
+		grid := newSpatialGrid(all)
+		for i, a := range all {
+			grid.near(a.Position, boundRadius(a)+maxRadius, all, func(b *Entity, _ float64) {
+				// Each pair is handled once, from its lower index
+				j := index[b]
+				if j <= i || friendly(a, b) || linked(a, b) {
+					return
+				}
+				overlap, impulse := w.collide(a, b)
+				if overlap == 0 {
+					return
+				}
+				if w.impact != nil {
+					w.impact(a, b, impulse)
+				}
+				deepest = math.Max(deepest, overlap)
+				if reported[[2]int{i, j}] {
+					return
+				}
+				reported[[2]int{i, j}] = true
+				if !w.newContact(a, b) || w.emit == nil {
+					return
+				}
+				_, _, point := contact(a, b)
+				w.emit(CollisionEvent{Envelope: w.envelope("collision"), A: a.ID, B: b.ID, Point: point})
+			})
+		}
+		if deepest < w.physics.CollisionSlop {
+			break
+		}
+	}
+	w.pruneContacts()
+}
+
+// Unit vector in the direction of v, or zero
+func unit(v Vector2) Vector2 {
+	mag := math.Hypot(v.X, v.Y)
+	if mag == 0 {
+		return Vector2{}
+	}
+	return Vector2{X: v.X / mag, Y: v.Y / mag}
+}
+
+// Separate and bounce two entities if they overlap, returning the overlap
+// depth, or zero if they were apart, and the impulse exchanged
+func (w *World) collide(a, b *Entity) (float64, float64) {
+	if !a.alive() || !b.alive() {
+		return 0, 0
+	}
+	// Capsules push along the normal between their closest points, but
+	// entities translate only, so off-center hits impart no spin
+	n, dist, _ := contact(a, b)
+	minDist := hitRadius(a) + hitRadius(b)
+	if dist >= minDist {
+		return 0, 0
+	}
+
+	// Linked entities collide with the mass of their whole group
+	invA, invB := 1/groupMass(a), 1/groupMass(b)
+
+	// Push apart in proportion to inverse mass
+	overlap := minDist - dist
+	shareA, shareB := invA/(invA+invB), invB/(invA+invB)
+	a.Position.X -= n.X * overlap * shareA
+	a.Position.Y -= n.Y * overlap * shareA
+	b.Position.X += n.X * overlap * shareB
+	b.Position.Y += n.Y * overlap * shareB
+	if config.Wrap {
+		// Separation can push an entity over an edge, keep it in the world
+		a.Position, b.Position = wrapPosition(a.Position), wrapPosition(b.Position)
+	}
+
+	// Exchange momentum along the normal if approaching, keeping the
+	// restitution fraction of the closing speed
+	vn := (b.Velocity.X-a.Velocity.X)*n.X + (b.Velocity.Y-a.Velocity.Y)*n.Y
+	impulse := 0.0
+	if vn < 0 {
+		impulse = -(1 + w.physics.Restitution) * vn / (invA + invB)
+		// Applied to the member alone, rigidifying afterwards spreads it
+		// across the group
+		a.Velocity.X -= impulse / a.Mass * n.X
+		a.Velocity.Y -= impulse / a.Mass * n.Y
+		b.Velocity.X += impulse / b.Mass * n.X
+		b.Velocity.Y += impulse / b.Mass * n.Y
+	}
+	return overlap, impulse
+}
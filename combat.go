@@ -0,0 +1,78 @@
+package main
+
+// Health an entity spawns with when collision damage is enabled
+const MaxHealth = 100
+
+// DamageEvent is sent when a collision wounds an entity
+type DamageEvent struct {
+	Envelope
+	ID     string  `json:"id"`
+	By     string  `json:"by"` // The other entity in the collision, or a projectile's shooter
+	Amount float64 `json:"amount"`
+	Health float64 `json:"health"` // Health left
+}
+
+// DestroyEvent is sent when damage takes an entity's health to zero, the
+// entity respawns after the usual delay
+type DestroyEvent struct {
+	Envelope
+	ID string `json:"id"`
+	By string `json:"by"`
+}
+
+// Health of a freshly spawned entity, zero when damage is disabled
+func spawnHealth() float64 {
+	if config.CollisionDamage <= 0 {
+		return 0
+	}
+	return MaxHealth
+}
+
+// Wound both entities of a collision by the velocity change the impulse gave
+// each, so light entities and hard hits take the most damage. The caller must
+// hold clientsMu.
+func applyImpactDamage(a, b *Entity, impulse float64) {
+	if config.CollisionDamage <= 0 || impulse <= 0 {
+		return
+	}
+	damage(a, attacker(b), config.CollisionDamage*impulse/a.Mass)
+	damage(b, attacker(a), config.CollisionDamage*impulse/b.Mass)
+}
+
+// Apply the game's effects of a collision in the main world: impact damage,
+// then spending any projectile involved. The caller must hold clientsMu.
+func collisionEffects(a, b *Entity, impulse float64) {
+	applyImpactDamage(a, b, impulse)
+	spendProjectiles(a, b)
+}
+
+// Entity credited with damage an entity deals, the shooter for projectiles
+func attacker(e *Entity) string {
+	if e.Owner != "" {
+		return e.Owner
+	}
+	return e.ID
+}
+
+// Refresh an entity's spawn protection flags for this tick
+func (w *World) updateProtection(entity *Entity) {
+	entity.Invulnerable = w.tick < entity.protectedTick
+	entity.InvulnerableFor = 0
+	if entity.Invulnerable {
+		entity.InvulnerableFor = float64(entity.protectedTick-w.tick) / TickRate
+	}
+}
+
+// Take health from an entity, destroying it at zero
+func damage(entity *Entity, by string, amount float64) {
+	if entity.Static || entity.Owner != "" || entity.Invulnerable || !entity.alive() {
+		return
+	}
+	entity.Health = max(entity.Health-amount, 0)
+	emitEvent(DamageEvent{Envelope: envelope("damage"), ID: entity.ID, By: by, Amount: amount, Health: entity.Health})
+	if entity.Health == 0 {
+		emitEvent(DestroyEvent{Envelope: envelope("destroy"), ID: entity.ID, By: by})
+		scoreDestroy(entity.ID, by)
+		world.kill(entity)
+	}
+}
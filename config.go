@@ -0,0 +1,757 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config holds runtime options set from the command line. Every flag can
+// also be set from an environment variable named SPACE_WEB_ and the flag name
+// in upper case with dashes as underscores, such as SPACE_WEB_ADDR or
+// SPACE_WEB_MAX_PLAYERS. Flags take precedence over the environment.
+type Config struct {
+	// Listen address (SPACE_WEB_ADDR)
+	Addr string
+
+	// Allowed websocket origins, comma separated, empty allows any
+	// (SPACE_WEB_ORIGINS)
+	Origins string
+
+	// Certificate and key files to serve TLS with, which also enables
+	// HTTP/2 for the REST endpoints. Empty serves plain HTTP/1.1.
+	TLSCert string
+	TLSKey  string
+
+	// Decimal places kept for positions and velocities on the wire, negative
+	// keeps full float64 precision. Two places is plenty for pixel rendering and
+	// roughly halves snapshot size, but clients that derive physics from the
+	// broadcast values will see small rounding errors.
+	Precision int
+
+	// Broadcast each moving entity's direction of travel, off by default to
+	// keep snapshots small
+	Heading bool
+
+	// Interval after which a heartbeat is sent if no data frames went out, zero disables
+	HeartbeatInterval time.Duration
+
+	// Wrap the world at its edges (toroidal space). Gravity then uses the
+	// minimum-image displacement, which changes orbital dynamics near the edges.
+	Wrap bool
+
+	// Despawn entities that cross the world boundary on an unbound orbit
+	// heading away from the star, as an alternative to wrapping. Bots are
+	// removed and players respawn.
+	DespawnUnbound bool
+
+	// Radius of the star, used for collisions and sent to clients for rendering
+	StarRadius float64
+
+	// Position of the star, which gravity and spawn orbits are centered on
+	StarX, StarY float64
+
+	// Spawn mass for players, and the range bot masses are drawn from
+	PlayerMass float64
+	BotMassMin float64
+	BotMassMax float64
+
+	// How bot velocities are drawn: "orbit" for the spawn orbit, "rest",
+	// "radial" for infall, or "maxwell" for a thermal gas cloud. Radial and
+	// maxwell speeds scale with BotVelocitySpread times the circular speed.
+	BotVelocity       string
+	BotVelocitySpread float64
+
+	// Bearer token for admin endpoints, empty disables them
+	AdminToken string
+
+	// Workers preparing per-client frames each tick. Socket writes happen on each
+	// connection's own writer goroutine, bounded by WriteTimeout.
+	BroadcastWorkers int
+	WriteTimeout     time.Duration
+
+	// Radius around the star inside which gravity stops growing, zero disables.
+	// This is an onboarding aid and breaks strict inverse-square accuracy.
+	SafeRadius float64
+
+	// Difficulty ramp of the gravity multiplier from 1 to GravityRampMax over
+	// GravityRampDuration: "none", "linear" or "exponential"
+	GravityRamp         string
+	GravityRampDuration time.Duration
+	GravityRampMax      float64
+
+	// Players allowed at once, later arrivals spectate in a queue. Zero is unlimited.
+	MaxPlayers int
+
+	// Atmosphere above the star surface with velocity-squared drag, zero
+	// altitude disables it. AtmosphereDrag is the coefficient at the surface.
+	AtmosphereAltitude float64
+	AtmosphereDrag     float64
+
+	// Time the simulation may sit empty before its loop stops, zero keeps it running
+	IdleGrace time.Duration
+
+	// Eccentricity of spawn orbits, 0 is circular and values must stay below 1
+	Eccentricity float64
+
+	// Disconnect players that send no input for this long, zero disables
+	IdleTimeout time.Duration
+
+	// Connections each IP may open per second over time, with bursts of up
+	// to SpawnBurst. Zero rate disables the limit.
+	SpawnRate  float64
+	SpawnBurst int
+
+	// Connections allowed between accept and registration at once, zero is
+	// unlimited. Beyond it new connections wait briefly, then are refused.
+	MaxConnectionSetups int
+
+	// Time a new connection has to send its first valid message before it
+	// is closed, zero disables
+	HandshakeTimeout time.Duration
+
+	// Malformed or unknown messages a connection may send, forgiven over
+	// ErrorDecay, before it is closed. Zero never closes.
+	ErrorBudget int
+
+	// NDJSON file recording every parsed client command, empty disables. When
+	// InputLogIDs (comma separated entity IDs) is set only those are recorded.
+	InputLog    string
+	InputLogIDs string
+
+	// Cap thrust at this multiple of the local gravitational acceleration, zero
+	// leaves only the absolute MaxThrust cap
+	ThrustGravityRatio float64
+
+	// Treat thrust as a force divided by the entity's current mass, its mass
+	// plus FuelMass per unit of fuel left, so burning fuel makes it nimbler
+	ThrustForce bool
+	FuelMass    float64
+
+	// Exhaust speed of thrust under the rocket equation, zero disables mass
+	// loss. Thrust expels mass down to DryMass, restored on respawn.
+	ExhaustSpeed float64
+	DryMass      float64
+
+	// Negotiate permessage-deflate, compressing frames of at least
+	// CompressionThreshold bytes
+	Compression          bool
+	CompressionThreshold int
+
+	// Cell size of the spatial hash grid used for neighbor queries, zero falls
+	// back to scanning every entity
+	GridCellSize float64
+
+	// Integrate sleeping bots only every SleepStride ticks, with a longer
+	// step. Bots sleep once their speed holds steady with nothing within
+	// SleepRadius, and wake when anything comes that close. Below 2 disables.
+	SleepStride int
+	SleepRadius float64
+
+	// Parameters of the main world's physics, which benchmark worlds start
+	// from as well
+	Physics
+
+	// Largest relative energy drift of the startup reference orbit before a
+	// warning, zero skips the check. Strict refuses to start instead.
+	StabilityTolerance float64
+	StrictStability    bool
+
+	// Distance exponent of the star's force law, 2 for inverse-square. Orbital
+	// elements and eccentric spawns still assume inverse-square.
+	GravityExponent float64
+
+	// Fraction of its radius an entity may move in one tick before a
+	// tunneling warning is logged, zero disables
+	TunnelFraction float64
+
+	// Largest coordinate magnitude an entity may reach before it is logged
+	// and respawned as a physics fault, zero disables the limit but not the
+	// check for NaN and infinite state
+	MaxCoordinate float64
+
+	// Farthest a position reported with a thrust may stray from the server's
+	// before the client is sent a correction, zero ignores reports
+	PositionTolerance float64
+
+	// Most items returned by one response of a list endpoint, such as a page
+	// of entities or the samples of the field
+	MaxResponseEntities int
+
+	// Named bundle of parameters applied under any flags given explicitly
+	Preset string
+
+	// Detect and report integer period resonances between orbits
+	Resonance bool
+
+	// Drop the broadcast rate while the average tick takes longer than TickBudget
+	AdaptiveRate bool
+	TickBudget   time.Duration
+
+	// Broadcast less often while the time scale leaves little motion per tick
+	CombineBroadcasts bool
+
+	// Outbound bytes per second across all clients, zero is unlimited. Over
+	// budget, snapshots are skipped so the effective broadcast rate drops.
+	BandwidthLimit int
+
+	// Bots allowed across the server, larger requests are truncated. Zero is unlimited.
+	MaxBots int
+
+	// Pin the physics goroutine to its own OS thread. This cuts scheduler
+	// jitter on the tick loop, but the thread is no longer available to other
+	// goroutines, and the fan-out workers still run wherever the scheduler
+	// puts them. Pair it with OS-level CPU pinning for NUMA placement.
+	LockPhysicsThread bool
+
+	// Log a warning with per-phase timings for ticks slower than this, zero disables
+	SlowTick time.Duration
+
+	// Largest number of entities in one snapshot frame, larger snapshots are
+	// split into pages. Zero never splits.
+	MaxFrameEntities int
+
+	// Random spawn positions tried before falling back to the first free
+	// ring slot around the star
+	SpawnAttempts int
+
+	// Fuel as a delta-v budget: the reserve each entity starts with and the
+	// rate it regenerates per second. Zero capacity means unlimited thrust.
+	FuelCapacity float64
+	FuelRegen    float64
+
+	// Zones where fuel regenerates faster, from repeated -refuel-zone flags
+	RefuelZones refuelZones
+
+	// Fixed gravitating bodies besides the star, from repeated -planet flags
+	Planets planets
+
+	// Snapshots broadcast per second, a divisor of the tick rate, so physics
+	// and network rates are tuned independently
+	BroadcastRate int
+
+	// Collision hitbox radius as a multiple of the rendered radius
+	CollisionRadiusScale float64
+
+	// Length of a scoring round, after which rankings are broadcast and
+	// scores reset. Zero disables rounds, scores then run until reset.
+	RoundDuration time.Duration
+
+	// Reset the simulation, or with RuntimeAction "exit" shut down, each
+	// time MaxRuntime elapses. Zero runs forever.
+	MaxRuntime    time.Duration
+	RuntimeAction string
+
+	// Gravity assist scoring ring, distances from the star. A pass through it
+	// on a bound orbit scores. Zero AssistOuter disables the ring.
+	AssistInner float64
+	AssistOuter float64
+
+	// Time a respawned entity is immune to collision damage
+	SpawnProtection time.Duration
+
+	// Health lost per unit of velocity change from a collision impulse, zero
+	// disables health and damage
+	CollisionDamage float64
+
+	// Time a pair must stay apart before touching again reports a new
+	// collision event, zero reports every tick they overlap
+	CollisionCooldown time.Duration
+
+	// Scenario to load at startup, as file:path or the built-in lagrange demo
+	Scenario string
+
+	// Autosave the state to this file every AutosaveInterval, in the export
+	// format. Load resumes from such a file and is shorthand for
+	// -scenario file:path.
+	Autosave         string
+	AutosaveInterval time.Duration
+	Load             string
+
+	// Cap speed at this fraction of the local escape velocity, zero disables.
+	// Non-physical, for a contained arena where nothing can escape.
+	EscapeSpeedFraction float64
+
+	// Remove the central star so only entity gravity acts, for pure n-body
+	// runs. Entities spawn at rest.
+	NoStar bool
+
+	// Replay physics steps for ticks the loop fell behind on, without
+	// broadcasting them, so simulated time keeps pace with wall time
+	CatchUp bool
+
+	// What the spectator camera hint follows: "mass" for the most massive
+	// entity, "density" for the most crowded neighborhood
+	CameraMode string
+
+	// How long a disconnected player's cookie profile is kept for them to
+	// return before it is dropped
+	ResumeGrace time.Duration
+
+	// Fraction of the gap between applied and requested thrust left each
+	// tick, zero applies inputs at once. 0.8 takes about 20 ticks to settle.
+	ThrustSmoothing float64
+
+	// URL that receives a JSON POST for server start, joins and leaves
+	WebhookURL string
+
+	// Testing only: delay every outbound frame by TestLatency plus or minus up
+	// to TestJitter, to exercise client interpolation without a real WAN
+	TestLatency time.Duration
+	TestJitter  time.Duration
+
+	// Benchmarking only: run this many extra worlds of BenchBots bots each,
+	// isolated from the main world and its clients
+	BenchWorlds int
+	BenchBots   int
+
+	// Physics overrides for the benchmark worlds in order, from repeated
+	// -bench-physics flags, with the rest running the main world's physics
+	BenchPhysics physicsOverrides
+}
+
+// Effective configuration, written once at startup before any goroutines start
+var config = Config{
+	Addr:                 ":8080",
+	Precision:            -1,
+	HeartbeatInterval:    time.Second,
+	StarRadius:           10,
+	PlayerMass:           1,
+	BotMassMin:           1,
+	BotMassMax:           1,
+	BotVelocity:          "orbit",
+	BotVelocitySpread:    0.5,
+	DryMass:              0.1,
+	BroadcastRate:        TickRate,
+	BroadcastWorkers:     runtime.NumCPU(),
+	WriteTimeout:         time.Second,
+	GravityRamp:          "none",
+	GravityRampDuration:  10 * time.Minute,
+	GravityRampMax:       2,
+	AtmosphereDrag:       0.01,
+	IdleGrace:            30 * time.Second,
+	CompressionThreshold: 512,
+	GridCellSize:         50,
+	SleepRadius:          100,
+	TickBudget:           10 * time.Millisecond,
+	MaxBots:              1000,
+	RuntimeAction:        "reset",
+	BenchBots:            100,
+	CameraMode:           "mass",
+	ResumeGrace:          10 * time.Minute,
+	AutosaveInterval:     time.Minute,
+	CollisionRadiusScale: 1,
+	SpawnAttempts:        10,
+	StabilityTolerance:   0.05,
+	MaxResponseEntities:  1024,
+	MaxCoordinate:        1e6,
+	PositionTolerance:    50,
+	TunnelFraction:       1,
+	SpawnProtection:      3 * time.Second,
+	GravityExponent:      2,
+	HandshakeTimeout:     10 * time.Second,
+	ErrorBudget:          20,
+	SpawnBurst:           5,
+	MaxConnectionSetups:  64,
+	Physics: Physics{
+		TimeScale:           1,
+		Gravity:             1,
+		Restitution:         1,
+		CollisionIterations: 1,
+		CollisionSlop:       0.01,
+	},
+}
+
+// Register and parse command line flags
+func parseFlags() {
+	flag.IntVar(&config.Precision, "precision", config.Precision, "decimal places for coordinates on the wire (negative for full precision)")
+	flag.BoolVar(&config.Heading, "heading", config.Heading, "broadcast each moving entity's direction of travel")
+	flag.DurationVar(&config.HeartbeatInterval, "heartbeat", config.HeartbeatInterval, "heartbeat interval when no data frames are sent (0 disables)")
+	flag.BoolVar(&config.Wrap, "wrap", config.Wrap, "wrap the world at its edges (toroidal space)")
+	flag.BoolVar(&config.DespawnUnbound, "despawn-unbound", config.DespawnUnbound, "despawn entities that leave the world on unbound orbits")
+	flag.Float64Var(&config.StarRadius, "star-radius", config.StarRadius, "radius of the central star")
+	flag.Float64Var(&config.PlayerMass, "player-mass", config.PlayerMass, "spawn mass of player entities")
+	flag.Float64Var(&config.BotMassMin, "bot-mass-min", config.BotMassMin, "minimum spawn mass of bots")
+	flag.Float64Var(&config.BotMassMax, "bot-mass-max", config.BotMassMax, "maximum spawn mass of bots")
+	flag.StringVar(&config.BotVelocity, "bot-velocity", config.BotVelocity, "bot velocity distribution: orbit, rest, radial or maxwell")
+	flag.Float64Var(&config.BotVelocitySpread, "bot-velocity-spread", config.BotVelocitySpread, "radial and maxwell bot speed as a fraction of circular speed")
+	flag.StringVar(&config.AdminToken, "admin-token", config.AdminToken, "bearer token for admin endpoints (empty disables them)")
+	flag.IntVar(&config.BroadcastWorkers, "broadcast-workers", config.BroadcastWorkers, "workers fanning out each broadcast to clients")
+	flag.DurationVar(&config.WriteTimeout, "write-timeout", config.WriteTimeout, "deadline for a single websocket write")
+	flag.Float64Var(&config.SafeRadius, "safe-radius", config.SafeRadius, "radius around the star with capped gravity (0 disables)")
+	flag.StringVar(&config.GravityRamp, "gravity-ramp", config.GravityRamp, "gravity difficulty ramp: none, linear or exponential")
+	flag.DurationVar(&config.GravityRampDuration, "gravity-ramp-duration", config.GravityRampDuration, "time for the gravity ramp to reach its maximum")
+	flag.Float64Var(&config.GravityRampMax, "gravity-ramp-max", config.GravityRampMax, "final gravity multiplier of the ramp")
+	flag.IntVar(&config.MaxPlayers, "max-players", config.MaxPlayers, "players allowed at once, others queue as spectators (0 is unlimited)")
+	flag.Float64Var(&config.AtmosphereAltitude, "atmosphere-altitude", config.AtmosphereAltitude, "height of the drag atmosphere above the star surface (0 disables)")
+	flag.Float64Var(&config.AtmosphereDrag, "atmosphere-drag", config.AtmosphereDrag, "velocity-squared drag coefficient at the star surface")
+	flag.DurationVar(&config.IdleGrace, "idle-grace", config.IdleGrace, "stop the physics loop after the simulation is empty this long (0 never stops)")
+	flag.Float64Var(&config.Eccentricity, "eccentricity", config.Eccentricity, "eccentricity of spawn orbits (0 circular, below 1)")
+	flag.DurationVar(&config.IdleTimeout, "idle-timeout", config.IdleTimeout, "disconnect players that send no input for this long (0 disables)")
+	flag.Float64Var(&config.SpawnRate, "spawn-rate", config.SpawnRate, "connections per second each IP may open over time (0 disables)")
+	flag.IntVar(&config.SpawnBurst, "spawn-burst", config.SpawnBurst, "connections an IP may open at once before the spawn rate applies")
+	flag.IntVar(&config.MaxConnectionSetups, "max-connection-setups", config.MaxConnectionSetups, "connections set up at once before new ones queue (0 is unlimited)")
+	flag.DurationVar(&config.HandshakeTimeout, "handshake-timeout", config.HandshakeTimeout, "close connections that send no valid message within this long (0 disables)")
+	flag.IntVar(&config.ErrorBudget, "error-budget", config.ErrorBudget, "malformed messages a connection may send, forgiven over time, before it is closed (0 disables)")
+	flag.StringVar(&config.InputLog, "input-log", config.InputLog, "file to record parsed client commands as NDJSON (empty disables)")
+	flag.StringVar(&config.InputLogIDs, "input-log-ids", config.InputLogIDs, "comma separated entity IDs to record (empty records all)")
+	flag.Float64Var(&config.ThrustGravityRatio, "thrust-gravity-ratio", config.ThrustGravityRatio, "cap thrust at this multiple of local gravity (0 disables)")
+	flag.BoolVar(&config.ThrustForce, "thrust-force", config.ThrustForce, "apply thrust as a force divided by current mass")
+	flag.Float64Var(&config.FuelMass, "fuel-mass", config.FuelMass, "mass carried per unit of fuel with -thrust-force")
+	flag.Float64Var(&config.ExhaustSpeed, "exhaust-speed", config.ExhaustSpeed, "exhaust speed for mass lost to thrust with -thrust-force (0 disables)")
+	flag.Float64Var(&config.DryMass, "dry-mass", config.DryMass, "mass below which thrust expels no more")
+	flag.BoolVar(&config.Compression, "compression", config.Compression, "negotiate permessage-deflate with clients")
+	flag.IntVar(&config.CompressionThreshold, "compression-threshold", config.CompressionThreshold, "minimum frame size in bytes to compress")
+	flag.Float64Var(&config.GridCellSize, "grid-cell", config.GridCellSize, "spatial grid cell size (0 disables the grid)")
+	flag.IntVar(&config.SleepStride, "sleep-stride", config.SleepStride, "ticks between steps of a sleeping bot (below 2 disables sleep)")
+	flag.Float64Var(&config.SleepRadius, "sleep-radius", config.SleepRadius, "distance within which another body keeps a bot awake")
+	flag.BoolVar(&config.NBody, "nbody", config.NBody, "enable gravity between entities")
+	flag.Float64Var(&config.GravityCutoff, "gravity-cutoff", config.GravityCutoff, "ignore entity gravity beyond this distance (0 disables the cutoff)")
+	flag.BoolVar(&config.Resonance, "resonance", config.Resonance, "detect and report orbital resonances")
+	flag.BoolVar(&config.AdaptiveRate, "adaptive-rate", config.AdaptiveRate, "reduce the broadcast rate when ticks run over budget")
+	flag.DurationVar(&config.TickBudget, "tick-budget", config.TickBudget, "tick processing time above which the broadcast rate drops")
+	flag.BoolVar(&config.CombineBroadcasts, "combine-broadcasts", config.CombineBroadcasts, "reduce the broadcast rate while a small time scale leaves little motion per tick")
+	flag.Float64Var(&config.StarX, "star-x", config.StarX, "x position of the star")
+	flag.Float64Var(&config.StarY, "star-y", config.StarY, "y position of the star")
+	flag.IntVar(&config.BandwidthLimit, "bandwidth-limit", config.BandwidthLimit, "outbound bytes per second across all clients (0 is unlimited)")
+	flag.IntVar(&config.MaxBots, "max-bots", config.MaxBots, "bots allowed across the server (0 is unlimited)")
+	flag.BoolVar(&config.LockPhysicsThread, "lock-physics-thread", config.LockPhysicsThread, "pin the physics goroutine to a dedicated OS thread")
+	flag.DurationVar(&config.SlowTick, "slow-tick", config.SlowTick, "log ticks slower than this with phase timings (0 disables)")
+	flag.IntVar(&config.CollisionIterations, "collision-iterations", config.CollisionIterations, "maximum collision resolution passes per tick")
+	flag.Float64Var(&config.CollisionSlop, "collision-slop", config.CollisionSlop, "overlap depth below which collision passes stop early")
+	flag.DurationVar(&config.CollisionCooldown, "collision-cooldown", config.CollisionCooldown, "separation needed before a touching pair reports another collision (0 reports every tick)")
+	flag.StringVar(&config.Scenario, "scenario", config.Scenario, "scenario to load at startup, as file:path or lagrange")
+	flag.DurationVar(&config.TestLatency, "test-latency", config.TestLatency, "TESTING ONLY: delay outbound frames by this much")
+	flag.DurationVar(&config.TestJitter, "test-jitter", config.TestJitter, "TESTING ONLY: vary the outbound delay by up to this much")
+	flag.IntVar(&config.BenchWorlds, "bench-worlds", config.BenchWorlds, "BENCHMARKING ONLY: independent bot worlds to run alongside the main one")
+	flag.IntVar(&config.BenchBots, "bench-bots", config.BenchBots, "BENCHMARKING ONLY: bots in each benchmark world")
+	flag.Var(&config.BenchPhysics, "bench-physics", "BENCHMARKING ONLY: physics of the next benchmark world as key=value pairs, such as gravity=2,restitution=0.5, repeatable")
+	flag.Float64Var(&config.EscapeSpeedFraction, "escape-fraction", config.EscapeSpeedFraction, "cap speed at this fraction of local escape velocity, non-physical (0 disables)")
+	flag.StringVar(&config.WebhookURL, "webhook", config.WebhookURL, "URL to POST JSON notifications of key events to")
+	flag.BoolVar(&config.CatchUp, "catch-up", config.CatchUp, "replay physics for missed ticks")
+	flag.StringVar(&config.CameraMode, "camera", config.CameraMode, "spectator camera hint: mass or density")
+	flag.DurationVar(&config.ResumeGrace, "resume-grace", config.ResumeGrace, "how long to keep a disconnected player's profile for reconnect")
+	flag.Float64Var(&config.ThrustSmoothing, "thrust-smoothing", config.ThrustSmoothing, "low-pass filter factor for applied thrust, in [0, 1) (0 disables)")
+	flag.BoolVar(&config.NoStar, "no-star", config.NoStar, "remove the central star, leaving only n-body gravity")
+	flag.Float64Var(&config.Restitution, "restitution", config.Restitution, "collision restitution, 1 is elastic and 0 is sticky")
+	flag.Float64Var(&config.CollisionRadiusScale, "collision-radius-scale", config.CollisionRadiusScale, "collision hitbox radius as a multiple of the rendered radius")
+	flag.DurationVar(&config.RoundDuration, "round", config.RoundDuration, "length of a scoring round (0 disables rounds)")
+	flag.DurationVar(&config.MaxRuntime, "max-runtime", config.MaxRuntime, "reset or stop the server after this long, for looping demos (0 runs forever)")
+	flag.StringVar(&config.RuntimeAction, "runtime-action", config.RuntimeAction, "what the runtime cap does: reset or exit")
+	flag.Float64Var(&config.AssistInner, "assist-inner", config.AssistInner, "inner radius of the gravity assist scoring ring")
+	flag.Float64Var(&config.AssistOuter, "assist-outer", config.AssistOuter, "outer radius of the gravity assist scoring ring (0 disables it)")
+	flag.DurationVar(&config.SpawnProtection, "spawn-protection", config.SpawnProtection, "time a respawned entity is immune to collision damage")
+	flag.Float64Var(&config.CollisionDamage, "collision-damage", config.CollisionDamage, "health lost per unit of collision velocity change (0 disables health)")
+	flag.StringVar(&config.Autosave, "autosave", config.Autosave, "file to periodically save the state to")
+	flag.DurationVar(&config.AutosaveInterval, "autosave-interval", config.AutosaveInterval, "time between autosaves")
+	flag.StringVar(&config.Load, "load", config.Load, "state file to resume from at startup, such as an autosave")
+	flag.IntVar(&config.SpawnAttempts, "spawn-attempts", config.SpawnAttempts, "random spawn positions tried before falling back to a free ring slot")
+	flag.IntVar(&config.MaxFrameEntities, "max-frame-entities", config.MaxFrameEntities, "split snapshots with more entities than this across frames (0 never splits)")
+	flag.Float64Var(&config.FuelCapacity, "fuel", config.FuelCapacity, "fuel reserve per entity as delta-v (0 is unlimited)")
+	flag.Float64Var(&config.FuelRegen, "fuel-regen", config.FuelRegen, "fuel regenerated per second")
+	flag.Var(&config.RefuelZones, "refuel-zone", "refuel zone as x,y,radius,rate, repeatable")
+	flag.Var(&config.Planets, "planet", "fixed gravitating body as x,y,mass,radius, repeatable")
+	flag.IntVar(&config.BroadcastRate, "broadcast-rate", config.BroadcastRate, "snapshots broadcast per second, a divisor of the tick rate")
+	flag.Float64Var(&config.Softening, "softening", config.Softening, "Plummer softening length for entity gravity")
+	flag.Float64Var(&config.StabilityTolerance, "stability-tolerance", config.StabilityTolerance, "relative energy drift allowed in the startup orbit check (0 skips it)")
+	flag.BoolVar(&config.StrictStability, "strict-stability", config.StrictStability, "refuse to start when the startup orbit check fails")
+	flag.IntVar(&config.MaxResponseEntities, "max-response-entities", config.MaxResponseEntities, "most items per response of a list endpoint, such as a page of entities")
+	flag.Float64Var(&config.TimeScale, "time-scale", config.TimeScale, "simulated seconds per real second")
+	flag.Float64Var(&config.GravityExponent, "gravity-exponent", config.GravityExponent, "distance exponent of the star's gravity, 2 is inverse-square")
+	flag.Float64Var(&config.TunnelFraction, "tunnel-fraction", config.TunnelFraction, "warn when an entity moves more than this fraction of its radius in a tick (0 disables)")
+	flag.Float64Var(&config.MaxCoordinate, "max-coordinate", config.MaxCoordinate, "respawn entities whose position exceeds this magnitude, as a physics fault (0 disables)")
+	flag.Float64Var(&config.PositionTolerance, "position-tolerance", config.PositionTolerance, "distance a client-reported position may stray before a correction is sent (0 ignores reports)")
+	flag.StringVar(&config.Preset, "preset", config.Preset, "parameter preset: "+strings.Join(presetNames(), ", "))
+	flag.StringVar(&config.Addr, "addr", config.Addr, "listen address")
+	flag.StringVar(&config.Origins, "origins", config.Origins, "allowed websocket origins, comma separated (empty allows any)")
+	flag.StringVar(&config.TLSCert, "tls-cert", config.TLSCert, "TLS certificate file, enables HTTPS and HTTP/2")
+	flag.StringVar(&config.TLSKey, "tls-key", config.TLSKey, "TLS private key file")
+	applyEnv()
+	flag.Parse()
+	applyPreset()
+
+	if config.PlayerMass <= 0 || config.BotMassMin <= 0 || config.BotMassMax < config.BotMassMin {
+		log.Fatal("Config error: masses must be positive and bot-mass-min <= bot-mass-max")
+	}
+	switch config.BotVelocity {
+	case "orbit", "rest", "radial", "maxwell":
+	default:
+		log.Fatal("Config error: bot velocity must be orbit, rest, radial or maxwell")
+	}
+	if config.BotVelocitySpread < 0 || !isFinite(config.BotVelocitySpread) {
+		log.Fatal("Config error: bot velocity spread must not be negative")
+	}
+	switch config.GravityRamp {
+	case "none", "linear", "exponential":
+	default:
+		log.Fatal("Config error: unknown gravity ramp ", config.GravityRamp)
+	}
+	switch config.CameraMode {
+	case "mass", "density":
+	default:
+		log.Fatal("Config error: unknown camera mode ", config.CameraMode)
+	}
+	if config.Eccentricity < 0 || config.Eccentricity >= 1 {
+		log.Fatal("Config error: eccentricity must be in [0, 1)")
+	}
+	if config.GravityRampDuration <= 0 || config.GravityRampMax <= 0 {
+		log.Fatal("Config error: gravity ramp duration and maximum must be positive")
+	}
+	if config.EscapeSpeedFraction < 0 || !isFinite(config.EscapeSpeedFraction) {
+		log.Fatal("Config error: escape fraction must not be negative")
+	}
+	if config.ThrustSmoothing < 0 || config.ThrustSmoothing >= 1 {
+		log.Fatal("Config error: thrust smoothing must be in [0, 1)")
+	}
+	if config.NoStar && !config.NBody {
+		log.Println("Warning: -no-star without -nbody leaves no gravity at all")
+	}
+	if config.Load != "" && config.Scenario != "" {
+		log.Fatal("Config error: -load and -scenario are exclusive")
+	}
+	scenarioSource = config.Scenario
+	if config.Load != "" {
+		scenarioSource = "file:" + config.Load
+	}
+	if scenarioSource != "" && scenarioSource != "lagrange" && !strings.HasPrefix(scenarioSource, "file:") {
+		log.Fatal("Config error: scenario must be file:path or lagrange")
+	}
+	if config.Autosave != "" && config.AutosaveInterval <= 0 {
+		log.Fatal("Config error: autosave interval must be positive")
+	}
+	if config.ResumeGrace <= 0 {
+		log.Fatal("Config error: resume grace must be positive")
+	}
+	if config.Wrap && config.DespawnUnbound {
+		log.Fatal("Config error: -wrap and -despawn-unbound are exclusive")
+	}
+	if config.TestLatency < 0 || config.TestJitter < 0 {
+		log.Fatal("Config error: test latency and jitter must not be negative")
+	}
+	if config.TestLatency > 0 || config.TestJitter > 0 {
+		log.Println("Warning: simulated latency is enabled, this is a testing tool")
+	}
+	if config.SleepStride < 0 || config.SleepRadius < 0 {
+		log.Fatal("Config error: sleep stride and radius must not be negative")
+	}
+	if config.BenchWorlds < 0 || config.BenchBots < 1 {
+		log.Fatal("Config error: bench worlds must not be negative and bench bots must be at least 1")
+	}
+	if len(config.BenchPhysics) > config.BenchWorlds {
+		log.Fatalf("Config error: %d bench physics overrides for %d bench worlds", len(config.BenchPhysics), config.BenchWorlds)
+	}
+	if config.MaxFrameEntities < 0 {
+		log.Fatal("Config error: max frame entities must not be negative")
+	}
+	if config.FuelCapacity < 0 || config.FuelRegen < 0 {
+		log.Fatal("Config error: fuel and fuel regen must not be negative")
+	}
+	if config.FuelMass < 0 || !isFinite(config.FuelMass) {
+		log.Fatal("Config error: fuel mass must not be negative")
+	}
+	if config.FuelMass > 0 && !config.ThrustForce {
+		log.Fatal("Config error: fuel mass needs -thrust-force")
+	}
+	if config.ExhaustSpeed < 0 || !isFinite(config.ExhaustSpeed) || config.DryMass <= 0 || !isFinite(config.DryMass) {
+		log.Fatal("Config error: exhaust speed must not be negative and dry mass must be positive")
+	}
+	if config.ExhaustSpeed > 0 && !config.ThrustForce {
+		log.Fatal("Config error: exhaust speed needs -thrust-force")
+	}
+	if config.SpawnAttempts < 1 {
+		log.Fatal("Config error: spawn attempts must be at least 1")
+	}
+	if !isFinite(config.TimeScale) || config.TimeScale < MinTimeScale || config.TimeScale > MaxTimeScale {
+		log.Fatalf("Config error: time scale must be between %g and %g", MinTimeScale, MaxTimeScale)
+	}
+	if step := TimeStep * config.TimeScale; step < MinSnapshotStep && !config.CombineBroadcasts {
+		log.Printf("Warning: time scale %g advances only %gs per tick, little will appear to move; consider -combine-broadcasts", config.TimeScale, step)
+	}
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		log.Fatal("Config error: TLS needs both a certificate and a key")
+	}
+	if config.SpawnRate < 0 || !isFinite(config.SpawnRate) || config.SpawnBurst < 1 {
+		log.Fatal("Config error: spawn rate must not be negative and spawn burst must be at least 1")
+	}
+	if config.MaxConnectionSetups < 0 {
+		log.Fatal("Config error: max connection setups must not be negative")
+	}
+	if config.ErrorBudget < 0 {
+		log.Fatal("Config error: error budget must not be negative")
+	}
+	if config.HandshakeTimeout < 0 {
+		log.Fatal("Config error: handshake timeout must not be negative")
+	}
+	if config.GravityExponent <= 0 || !isFinite(config.GravityExponent) {
+		log.Fatal("Config error: gravity exponent must be positive")
+	}
+	if config.TunnelFraction < 0 || !isFinite(config.TunnelFraction) {
+		log.Fatal("Config error: tunnel fraction must not be negative")
+	}
+	if config.PositionTolerance < 0 || !isFinite(config.PositionTolerance) {
+		log.Fatal("Config error: position tolerance must not be negative")
+	}
+	if config.MaxCoordinate < 0 || !isFinite(config.MaxCoordinate) {
+		log.Fatal("Config error: max coordinate must not be negative")
+	}
+	if config.MaxResponseEntities < 1 {
+		log.Fatal("Config error: max response entities must be at least 1")
+	}
+	if config.Softening < 0 {
+		log.Fatal("Config error: softening must not be negative")
+	}
+	if config.RoundDuration < 0 {
+		log.Fatal("Config error: round duration must not be negative")
+	}
+	if config.MaxRuntime < 0 || (config.RuntimeAction != "reset" && config.RuntimeAction != "exit") {
+		log.Fatal("Config error: max runtime must not be negative and the runtime action must be reset or exit")
+	}
+	if config.AssistOuter != 0 && (config.AssistInner < 0 || config.AssistOuter <= config.AssistInner || !isFinite(config.AssistOuter)) {
+		log.Fatal("Config error: assist ring needs 0 <= inner < outer")
+	}
+	if config.AssistOuter != 0 && config.NoStar {
+		log.Fatal("Config error: the assist ring needs the star")
+	}
+	if config.SpawnProtection < 0 {
+		log.Fatal("Config error: spawn protection must not be negative")
+	}
+	if config.CollisionDamage < 0 || !isFinite(config.CollisionDamage) {
+		log.Fatal("Config error: collision damage must not be negative")
+	}
+	if config.Restitution < 0 || config.Restitution > 1 {
+		log.Fatal("Config error: restitution must be in [0, 1]")
+	}
+	if config.CollisionRadiusScale <= 0 || !isFinite(config.CollisionRadiusScale) {
+		log.Fatal("Config error: collision radius scale must be positive")
+	}
+	if config.CollisionIterations < 1 || config.CollisionSlop < 0 {
+		log.Fatal("Config error: collision iterations must be at least 1 and slop non-negative")
+	}
+	if config.BroadcastRate < 1 || config.BroadcastRate > TickRate || TickRate%config.BroadcastRate != 0 {
+		log.Fatalf("Config error: broadcast rate must divide the tick rate of %d", TickRate)
+	}
+	if config.CollisionCooldown < 0 {
+		log.Fatal("Config error: collision cooldown must not be negative")
+	}
+}
+
+// Presets map flag names to values, so they go through the same parsing and
+// validation as the command line. The time step is fixed and not presettable.
+var presets = map[string]map[string]string{
+	"stable-orbits": {
+		"eccentricity":         "0",
+		"nbody":                "false",
+		"safe-radius":          "20",
+		"collision-iterations": "1",
+	},
+	"chaotic": {
+		"eccentricity":   "0.5",
+		"nbody":          "true",
+		"gravity-cutoff": "0",
+		"softening":      "1",
+	},
+	"dense-cluster": {
+		"nbody":                "true",
+		"gravity-cutoff":       "100",
+		"softening":            "5",
+		"collision-iterations": "4",
+		"restitution":          "0.5",
+	},
+}
+
+// Sorted preset names
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply the selected preset to every flag not given on the command line
+func applyPreset() {
+	if config.Preset == "" {
+		return
+	}
+	preset, ok := presets[config.Preset]
+	if !ok {
+		log.Fatal("Config error: unknown preset ", config.Preset)
+	}
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, value := range preset {
+		if !explicit[name] {
+			if err := flag.Set(name, value); err != nil {
+				log.Fatal("Config error: preset ", config.Preset, ": ", err)
+			}
+		}
+	}
+}
+
+// Prefix of environment variables that set flags
+const EnvPrefix = "SPACE_WEB_"
+
+// Environment variable that sets a flag
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Set flags from the environment, before the command line is parsed so flags
+// win. Values set this way count as explicit when a preset is applied.
+func applyEnv() {
+	flag.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := flag.Set(f.Name, value); err != nil {
+				log.Fatal("Config error: ", envName(f.Name), ": ", err)
+			}
+		}
+	})
+}
+
+// Flags whose values are logged as set or unset rather than in full
+var secretFlags = map[string]bool{"admin-token": true, "webhook": true}
+
+// Log the effective configuration once flags, environment and preset are
+// resolved: a summary of the main settings, then every flag's value
+func logConfig() {
+	scenario := config.Scenario
+	if config.Load != "" {
+		scenario = "load " + config.Load
+	}
+	if scenario == "" {
+		scenario = "none"
+	}
+	log.Printf("Config: listening on %s, G %g, star mass %g, %d Hz ticks, time step %gs at scale %g, scenario %s",
+		config.Addr, float64(G), starMass(), TickRate, float64(TimeStep), config.TimeScale, scenario)
+	log.Printf("Config: max players %d, max bots %d, max frame entities %d, spawn rate %g/s, runtime cap %v",
+		config.MaxPlayers, config.MaxBots, config.MaxFrameEntities, config.SpawnRate, config.MaxRuntime)
+
+	var enabled []string
+	for _, stage := range pipelineStages() {
+		if stage.Enabled {
+			enabled = append(enabled, stage.Name)
+		}
+		for _, step := range stage.Steps {
+			if stage.Enabled && step.Enabled {
+				enabled = append(enabled, step.Name)
+			}
+		}
+	}
+	log.Printf("Config: physics stages %s", strings.Join(enabled, ", "))
+
+	var values []string
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "(set)"
+		}
+		values = append(values, fmt.Sprintf("-%s=%s", f.Name, value))
+	})
+	log.Printf("Config: %s", strings.Join(values, " "))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// EntityDelta carries an entity's ID and only the fields that changed since
+// the last delta sent to the client. New entities carry every field.
+type EntityDelta struct {
+	ID        string
+	Name      *string         `json:",omitempty"`
+	Position  *Vector2        `json:",omitempty"`
+	Velocity  *Vector2        `json:",omitempty"`
+	Mass      *float64        `json:",omitempty"`
+	Radius    *float64        `json:",omitempty"`
+	Thrust    *Vector2        `json:",omitempty"`
+	Meta      json.RawMessage `json:",omitempty"`
+	State     *EntityState    `json:",omitempty"`
+	Connected *bool           `json:",omitempty"`
+	Fuel      *float64        `json:",omitempty"`
+	Health    *float64        `json:",omitempty"`
+	Static    *bool           `json:",omitempty"`
+	Owner     *string         `json:",omitempty"`
+	Group     *string         `json:",omitempty"`
+	Phase     *float64        `json:",omitempty"`
+	Heading   *float64        `json:",omitempty"`
+
+	Orientation *float64 `json:",omitempty"`
+
+	Invulnerable    *bool    `json:",omitempty"`
+	InvulnerableFor *float64 `json:",omitempty"`
+
+	Shape  *Shape   `json:",omitempty"`
+	Length *float64 `json:",omitempty"`
+}
+
+// DeltaMessage replaces the snapshot for clients that asked for field-level
+// deltas
+type DeltaMessage struct {
+	Envelope
+	Star     Star          `json:"star"`
+	Gravity  float64       `json:"gravity"`
+	Rate     int           `json:"rate"`
+	Scale    float64       `json:"scale"`
+	Paused   bool          `json:"paused"`
+	Entities []EntityDelta `json:"entities"`          // Only entities with changes
+	Removed  []string      `json:"removed,omitempty"` // Entities gone since the last delta
+}
+
+// Report whether two headings are equal, both absent counting as equal
+func sameHeading(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Diff an entity against the state last sent, reporting whether anything
+// changed. A nil previous state counts every field as changed.
+func diffEntity(prev *Entity, e *Entity) (EntityDelta, bool) {
+	d := EntityDelta{ID: e.ID}
+	changed := prev == nil
+	if prev == nil || prev.Name != e.Name {
+		d.Name, changed = &e.Name, true
+	}
+	if prev == nil || prev.Position != e.Position {
+		d.Position, changed = &e.Position, true
+	}
+	if prev == nil || prev.Velocity != e.Velocity {
+		d.Velocity, changed = &e.Velocity, true
+	}
+	if prev == nil || prev.Mass != e.Mass {
+		d.Mass, changed = &e.Mass, true
+	}
+	if prev == nil || prev.Radius != e.Radius {
+		d.Radius, changed = &e.Radius, true
+	}
+	if prev == nil || prev.Thrust != e.Thrust {
+		d.Thrust, changed = &e.Thrust, true
+	}
+	if prev == nil || !bytes.Equal(prev.Meta, e.Meta) {
+		d.Meta, changed = e.Meta, true
+	}
+	if prev == nil || prev.State != e.State {
+		d.State, changed = &e.State, true
+	}
+	if prev == nil || prev.Connected != e.Connected {
+		d.Connected, changed = &e.Connected, true
+	}
+	if prev == nil || prev.Fuel != e.Fuel {
+		d.Fuel, changed = &e.Fuel, true
+	}
+	if prev == nil || prev.Health != e.Health {
+		d.Health, changed = &e.Health, true
+	}
+	if prev == nil || prev.Static != e.Static {
+		d.Static, changed = &e.Static, true
+	}
+	if prev == nil || prev.Owner != e.Owner {
+		d.Owner, changed = &e.Owner, true
+	}
+	if prev == nil || prev.Group != e.Group {
+		d.Group, changed = &e.Group, true
+	}
+	if prev == nil || prev.Phase != e.Phase {
+		d.Phase, changed = &e.Phase, true
+	}
+	if prev == nil || !sameHeading(prev.Heading, e.Heading) {
+		d.Heading, changed = e.Heading, true
+	}
+	if prev == nil || prev.Orientation != e.Orientation {
+		d.Orientation, changed = &e.Orientation, true
+	}
+	if prev == nil || prev.Invulnerable != e.Invulnerable {
+		d.Invulnerable, changed = &e.Invulnerable, true
+	}
+	if prev == nil || prev.InvulnerableFor != e.InvulnerableFor {
+		d.InvulnerableFor, changed = &e.InvulnerableFor, true
+	}
+	if prev == nil || prev.Shape != e.Shape {
+		d.Shape, changed = &e.Shape, true
+	}
+	if prev == nil || prev.Length != e.Length {
+		d.Length, changed = &e.Length, true
+	}
+	return d, changed
+}
+
+// Marshal the client's delta against what it was last sent and remember the
+// new state. The caller must hold clientsMu.
+func deltaFrame(client *Client, update ClientUpdate) []byte {
+	env := update.Envelope
+	env.Type = "delta"
+	msg := DeltaMessage{
+		Envelope: env,
+		Star:     update.Star,
+		Gravity:  update.Gravity,
+		Rate:     update.Rate,
+		Scale:    update.Scale,
+		Paused:   update.Paused,
+		Entities: []EntityDelta{},
+	}
+	sent := make(map[string]Entity, len(update.Entities))
+	for i := range update.Entities {
+		e := &update.Entities[i]
+		var prev *Entity
+		if p, ok := client.sent[e.ID]; ok {
+			prev = &p
+		}
+		if d, changed := diffEntity(prev, e); changed {
+			msg.Entities = append(msg.Entities, d)
+		}
+		sent[e.ID] = *e
+	}
+	for id := range client.sent {
+		if _, ok := sent[id]; !ok {
+			msg.Removed = append(msg.Removed, id)
+		}
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logThrottled("JSON error:", err)
+		return nil
+	}
+	client.sent = sent
+	return data
+}
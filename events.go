@@ -0,0 +1,102 @@
+package main
+
+import "math"
+
+// Slingshot detection parameters
+const (
+	SlingshotRadius  = 2 * MinDistance // Distance from the star counted as a close perihelion pass
+	SlingshotMinGain = 5               // Minimum speed gain to report
+)
+
+// Version of the outgoing message protocol
+const ProtocolVersion = 1
+
+// Envelope heads every outgoing message so clients can dispatch on type and
+// reject versions they do not understand
+type Envelope struct {
+	V    int    `json:"v"`
+	Type string `json:"type"`
+	Tick uint64 `json:"tick"` // Authoritative simulation tick the message belongs to
+}
+
+// Create the envelope for a message type in the main world, the caller must
+// hold clientsMu
+func envelope(messageType string) Envelope {
+	return world.envelope(messageType)
+}
+
+// WelcomeMessage answers a join with the player's identity
+type WelcomeMessage struct {
+	Envelope
+	ID   string `json:"id"`
+	Name string `json:"name"` // Final name after uniqueness suffixing
+}
+
+// AckMessage confirms a buffered input
+type AckMessage struct {
+	Envelope
+	T int64 `json:"t"` // Timestamp of the acknowledged input
+}
+
+// LeaveEvent is sent when an entity is removed from the simulation
+type LeaveEvent struct {
+	Envelope
+	ID string `json:"id"`
+}
+
+// SlingshotEvent is sent when an entity leaves a close pass of the star
+// faster than it entered
+type SlingshotEvent struct {
+	Envelope
+	ID     string  `json:"id"`
+	DeltaV float64 `json:"deltaV"` // Speed gained over what the fixed potential gives back at the exit point
+}
+
+// HeartbeatMessage tells idle clients the connection is alive
+type HeartbeatMessage struct {
+	Envelope
+	T int64 `json:"t"` // Server time in Unix milliseconds
+}
+
+// Queue an event for the next broadcast, the caller must hold clientsMu
+func emitEvent(event Message) {
+	events = append(events, event)
+}
+
+// Track close perihelion passes of the star and report the speed gained over
+// each. Entry and exit speeds are compared through the energy on entering, so
+// the difference in the star's, planets' and wells' potential between the
+// two points does not count. What is left is the pull of other entities
+// during the pass, so in practice gains need -nbody. Thrusting during a pass
+// abandons it. The caller must hold clientsMu.
+func checkSlingshot(entity *Entity) {
+	if config.NoStar || !entity.alive() || entity.Thrust != (Vector2{}) {
+		entity.approaching = false
+		return
+	}
+	v := entity.Velocity
+	d := displacement(starPosition(), entity.Position)
+	if math.Hypot(d.X, d.Y) < SlingshotRadius {
+		if !entity.approaching {
+			entity.approaching = true
+			entity.approachEnergy = (v.X*v.X+v.Y*v.Y)/2 + world.potential(stepMidpoint(entity))
+		}
+		return
+	}
+	if !entity.approaching {
+		return
+	}
+	entity.approaching = false
+	expected := math.Sqrt(math.Max(0, 2*(entity.approachEnergy-world.potential(stepMidpoint(entity)))))
+	if gain := math.Hypot(v.X, v.Y) - expected; gain > SlingshotMinGain {
+		emitEvent(SlingshotEvent{Envelope: envelope("slingshot"), ID: entity.ID, DeltaV: gain})
+	}
+}
+
+// Position halfway through the last step. The semi-implicit step pairs the
+// velocity with the middle of the step rather than its end, and measuring
+// the potential there keeps the energy steady over a fast pass.
+func stepMidpoint(entity *Entity) Vector2 {
+	dt := world.timeStep() / 2
+	return Vector2{X: entity.Position.X - entity.Velocity.X*dt, Y: entity.Position.Y - entity.Velocity.Y*dt}
+}
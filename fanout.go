@@ -0,0 +1,49 @@
+package main
+
+import "sync"
+
+// fanJob is one client's share of a fan-out
+type fanJob struct {
+	client *Client
+	fn     func(*Client)
+	done   *sync.WaitGroup
+}
+
+// Workers shared by every fan-out for the life of the process, started on
+// first use so ticks do not pay for spawning goroutines
+var (
+	fanJobs    chan fanJob
+	fanStarted sync.Once
+)
+
+// Start the worker pool that runs fan-out jobs
+func startFanOut(workers int) {
+	fanJobs = make(chan fanJob)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range fanJobs {
+				job.fn(job.client)
+				job.done.Done()
+			}
+		}()
+	}
+}
+
+// Run fn for every client across the worker pool and wait for all of them.
+// The pool is sized by the workers of the first call.
+func fanOut(targets []*Client, workers int, fn func(*Client)) {
+	if workers <= 1 || len(targets) <= 1 {
+		for _, client := range targets {
+			fn(client)
+		}
+		return
+	}
+	fanStarted.Do(func() { startFanOut(workers) })
+
+	var done sync.WaitGroup
+	done.Add(len(targets))
+	for _, client := range targets {
+		fanJobs <- fanJob{client: client, fn: fn, done: &done}
+	}
+	done.Wait()
+}
@@ -2,4 +2,4 @@ module space-web
 
 go 1.23.2
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require github.com/gorilla/websocket v1.5.3
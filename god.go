@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// God entity parameters
+const (
+	GodID   = "god"
+	GodMass = 50
+)
+
+// Position the god entity is held at, nil until the first command. Guarded by
+// clientsMu.
+var godTarget *Vector2
+
+// GodRequest is the body accepted by the god position endpoint
+type GodRequest struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Move the god entity to an absolute position, creating it on first use. It
+// ignores physics but still collides and, with -nbody, pulls on others.
+func godPositionHandler(w http.ResponseWriter, r *http.Request) {
+	var req GodRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !isFinite(req.X) || !isFinite(req.Y) {
+		http.Error(w, "invalid position", http.StatusBadRequest)
+		return
+	}
+	target := Vector2{X: req.X, Y: req.Y}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	god := bots[GodID]
+	if god == nil {
+		entity := world.newEntity(GodID, GodMass)
+		entity.Name = "God"
+		entity.Static = true
+		entity.Position = target
+		entity.Velocity = Vector2{}
+		if insertBots([]Entity{entity}) == nil {
+			http.Error(w, "bot limit reached", http.StatusConflict)
+			return
+		}
+		god = bots[GodID]
+	}
+	godTarget = &target
+	writeJSON(w, *god)
+}
+
+// Remove the god entity
+func godRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if bots[GodID] == nil {
+		http.Error(w, "no god entity", http.StatusNotFound)
+		return
+	}
+	delete(bots, GodID)
+	godTarget = nil
+	emitEvent(LeaveEvent{Envelope: envelope("leave"), ID: GodID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Hold the god entity at its commanded position, with the velocity of the
+// move so collisions carry its momentum. The caller must hold clientsMu.
+func applyGod() {
+	god := bots[GodID]
+	if god == nil || godTarget == nil {
+		return
+	}
+	d := displacement(god.Position, *godTarget)
+	god.Velocity = Vector2{X: d.X / world.timeStep(), Y: d.Y / world.timeStep()}
+	god.Position = *godTarget
+}
@@ -0,0 +1,68 @@
+package main
+
+import "math"
+
+// SpatialGrid buckets entities into square cells for neighbor queries
+type SpatialGrid struct {
+	cellSize float64
+	cells    map[[2]int][]*Entity
+}
+
+// Bucket entities by position, or return nil when the grid is disabled
+func newSpatialGrid(all []*Entity) *SpatialGrid {
+	if config.GridCellSize <= 0 {
+		return nil
+	}
+	return gridOf(all, config.GridCellSize)
+}
+
+// Bucket entities by position into cells of the given size
+func gridOf(all []*Entity, cellSize float64) *SpatialGrid {
+	g := &SpatialGrid{cellSize: cellSize, cells: make(map[[2]int][]*Entity)}
+	for _, entity := range all {
+		key := g.cellOf(entity.Position)
+		g.cells[key] = append(g.cells[key], entity)
+	}
+	return g
+}
+
+// Cell containing a position
+func (g *SpatialGrid) cellOf(pos Vector2) [2]int {
+	if config.Wrap {
+		pos = wrapPosition(pos)
+	}
+	return [2]int{int(math.Floor(pos.X / g.cellSize)), int(math.Floor(pos.Y / g.cellSize))}
+}
+
+// Call fn for every entity within radius of pos. With a nil grid, every
+// entity in all is scanned instead.
+func (g *SpatialGrid) near(pos Vector2, radius float64, all []*Entity, fn func(*Entity, float64)) {
+	visit := func(entity *Entity) {
+		d := displacement(pos, entity.Position)
+		if dist := math.Hypot(d.X, d.Y); dist <= radius {
+			fn(entity, dist)
+		}
+	}
+	if g == nil {
+		for _, entity := range all {
+			visit(entity)
+		}
+		return
+	}
+
+	span := int(math.Ceil(radius / g.cellSize))
+	seen := make(map[[2]int]bool)
+	for i := -span; i <= span; i++ {
+		for j := -span; j <= span; j++ {
+			// Stepping by whole cells from pos keeps wrapped cells correct
+			key := g.cellOf(Vector2{X: pos.X + float64(i)*g.cellSize, Y: pos.Y + float64(j)*g.cellSize})
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			for _, entity := range g.cells[key] {
+				visit(entity)
+			}
+		}
+	}
+}
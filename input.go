@@ -0,0 +1,476 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Input handling limits
+const (
+	MaxThrust      = 200             // Maximum thrust acceleration, or force with -thrust-force
+	MaxInputLead   = time.Second     // Inputs timestamped further ahead are rejected
+	MaxQueuedInput = 64              // Inputs buffered per connection
+	InputLateness  = 2 * time.Second // Inputs older than this are dropped as stale
+	MaxMetaSize    = 256             // Bytes of client metadata stored per entity
+	MaxLaunchSpeed = 300             // Largest launch velocity a join may request
+	MaxNameLength  = 24              // Characters allowed in a display name
+
+	ThrustFxInterval = 100 * time.Millisecond // Minimum time between exhaust events per entity
+	ThrustSnap       = 0.01                   // Smoothed thrust this close to the request snaps to it
+	RenameCooldown   = 10 * time.Second       // Minimum time between renames after the first join
+	MaxImpulse       = 50                     // Largest velocity change one impulse may apply
+	ImpulseCooldown  = 500 * time.Millisecond // Minimum time between impulses per entity
+)
+
+// Binary input protocol
+const (
+	BinarySubprotocol = "space-web.binary"
+	BinaryInputSize   = 17
+	BinaryThrust      = 1
+)
+
+// ClientMessage is a message received from a client
+type ClientMessage struct {
+	Type string  `json:"type"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"` // Thrust, or the aim of a fire
+	T    int64   `json:"t"` // Server time in Unix milliseconds the input applies at, 0 for immediately
+
+	Meta    json.RawMessage `json:"meta"` // Join only, echoed verbatim in broadcasts
+	Name    string          `json:"name"` // Join only, display name
+	VX      *float64        `json:"vx"`   // Join only, optional launch velocity
+	VY      *float64        `json:"vy"`
+	Enabled bool            `json:"enabled"` // Toggles such as predict, batch and delta
+	Hz      float64         `json:"hz"`      // Rate only, snapshots per second wanted
+	Mode    string          `json:"mode"`    // Autopilot only
+	DX      float64         `json:"dx"`      // Impulse only, velocity change
+	DY      float64         `json:"dy"`
+	Target  string          `json:"target"` // Link only, entity to link to
+	Shape   Shape           `json:"shape"`  // Join only, collision shape
+	Length  float64         `json:"length"` // Join only, capsule segment length
+	Mass    float64         `json:"mass"`   // Gravity well only, with x and y its position
+	TTL     float64         `json:"ttl"`    // Gravity well only, seconds it lasts
+	Thrust  json.RawMessage `json:"thrust"` // Input: true fires the engine; untyped: a one-tick thrust vector
+	Rotate  float64         `json:"rotate"` // Input only, turn rate in [-1, 1]
+
+	// Thrust only, the client's predicted position, checked but never applied
+	Position *Vector2 `json:"position"`
+
+	once bool // Thrust only, set for the untyped form so it lasts a single tick
+}
+
+// ThrustInput is a buffered thrust or ship control command
+type ThrustInput struct {
+	At     time.Time
+	Thrust Vector2
+	Once   bool  // Thrust is dropped again after one tick
+	Helm   *helm // Ship controls from an input message, applied instead of Thrust
+}
+
+// Report whether a value is neither NaN nor infinite
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// Clamp a vector to a maximum magnitude
+func clampMagnitude(v Vector2, max float64) Vector2 {
+	mag := math.Hypot(v.X, v.Y)
+	if mag <= max || mag == 0 {
+		return v
+	}
+	return Vector2{X: v.X / mag * max, Y: v.Y / mag * max}
+}
+
+// Parse and route a message from a client. Binary frames are only accepted on
+// connections that negotiated the binary subprotocol. Malformed messages are
+// logged, ignored and charged against the error budget.
+func handleMessage(client *Client, messageType int, data []byte) {
+	msg, err := decodeMessage(client.binary, messageType, data)
+	if err != nil {
+		logThrottled("Message error:", err)
+		client.chargeError()
+		return
+	}
+	routeMessage(client, msg)
+}
+
+// Decode a frame as JSON, or as a binary message when the connection
+// negotiated the binary subprotocol
+func decodeMessage(binary bool, messageType int, data []byte) (ClientMessage, error) {
+	var msg ClientMessage
+	if messageType == websocket.BinaryMessage && binary {
+		return decodeBinaryMessage(data)
+	}
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// Decode a fixed-layout little-endian binary message:
+//
+//	byte 0      message kind (1 = thrust)
+//	bytes 1-4   x as float32
+//	bytes 5-8   y as float32
+//	bytes 9-16  t as int64 Unix milliseconds
+func decodeBinaryMessage(data []byte) (ClientMessage, error) {
+	if len(data) != BinaryInputSize {
+		return ClientMessage{}, fmt.Errorf("binary message is %d bytes, want %d", len(data), BinaryInputSize)
+	}
+	if data[0] != BinaryThrust {
+		return ClientMessage{}, fmt.Errorf("unknown binary message kind %d", data[0])
+	}
+	return ClientMessage{
+		Type: "thrust",
+		X:    float64(math.Float32frombits(binary.LittleEndian.Uint32(data[1:5]))),
+		Y:    float64(math.Float32frombits(binary.LittleEndian.Uint32(data[5:9]))),
+		T:    int64(binary.LittleEndian.Uint64(data[9:17])),
+	}, nil
+}
+
+// Act on a decoded client message. Handlers report whether they accepted
+// it, and only accepted messages count as activity for the idle timeout.
+func routeMessage(client *Client, msg ClientMessage) {
+	clientsMu.Lock()
+	id := client.Entity.ID
+	clientsMu.Unlock()
+
+	if msg.Type == "" && msg.Thrust != nil {
+		var v Vector2
+		if err := json.Unmarshal(msg.Thrust, &v); err != nil {
+			logThrottled("Message error:", err)
+			client.chargeError()
+			return
+		}
+		msg.Type, msg.X, msg.Y, msg.once = "thrust", v.X, v.Y, true
+	}
+
+	switch msg.Type {
+	case "thrust", "join", "predict", "rate", "batch", "resync", "autopilot", "impulse", "delta", "fire", "link", "unlink", "gravity_well", "input":
+		countMessages("in", msg.Type, 1)
+	default:
+		countMessages("in", "unknown", 1)
+	}
+	ok := false
+	switch msg.Type {
+	case "thrust":
+		ok = handleThrust(client, msg)
+	case "input":
+		ok = handleInput(client, msg)
+	case "join":
+		ok = handleJoin(client, msg)
+	case "predict":
+		clientsMu.Lock()
+		client.predict = msg.Enabled
+		clientsMu.Unlock()
+		ok = true
+	case "rate":
+		ok = handleRate(client, msg)
+	case "batch":
+		clientsMu.Lock()
+		client.batchEvents = msg.Enabled
+		clientsMu.Unlock()
+		ok = true
+	case "resync":
+		ok = handleResync(client)
+	case "delta":
+		clientsMu.Lock()
+		client.deltas, client.sent = msg.Enabled, nil
+		clientsMu.Unlock()
+		ok = true
+	case "autopilot":
+		ok = handleAutopilot(client, msg)
+	case "impulse":
+		ok = handleImpulse(client, msg)
+	case "fire":
+		ok = handleFire(client, msg)
+	case "link":
+		ok = handleLink(client, msg)
+	case "unlink":
+		ok = handleUnlink(client)
+	case "gravity_well":
+		ok = handleGravityWell(client, msg)
+	default:
+		log.Println("Message error: unknown type", msg.Type)
+		client.chargeError()
+	}
+
+	recordInput(id, msg, ok)
+	if ok {
+		clientsMu.Lock()
+		client.lastInput = time.Now()
+		clientsMu.Unlock()
+	}
+}
+
+// Buffer a thrust command, {"type":"thrust","x":0.5,"y":-0.2}, for the tick
+// it applies at. The vector is an acceleration on the sender's own entity,
+// clamped to MaxThrust, and it stays applied every tick until the next
+// thrust message replaces it, so {"type":"thrust"} with no vector stops it.
+//
+// The untyped {"thrust":{"X":0.5,"Y":-0.2}} form is accepted as an alias with
+// the same clamp, but it resets to zero after being applied for one tick, so
+// a client using it has to keep sending to keep accelerating.
+func handleThrust(client *Client, msg ClientMessage) bool {
+	at, ok := inputTime(msg)
+	if !ok {
+		log.Println("Message error: thrust timestamp out of range")
+		return false
+	}
+	if !isFinite(msg.X) || !isFinite(msg.Y) {
+		log.Println("Message error: invalid thrust")
+		return false
+	}
+	input := ThrustInput{At: at, Thrust: thrustOf(msg), Once: msg.once}
+
+	clientsMu.Lock()
+	if outOfFuel(&client.Entity) && (input.Thrust.X != 0 || input.Thrust.Y != 0) {
+		clientsMu.Unlock()
+		log.Println("Message error: out of fuel")
+		return false
+	}
+	client.queueInput(input)
+	if msg.Position != nil {
+		checkReportedPosition(client, *msg.Position)
+	}
+	client.send(AckMessage{Envelope: envelope("ack"), T: msg.T})
+	clientsMu.Unlock()
+	return true
+}
+
+// Time a buffered input applies at, reporting false when it is too far
+// ahead or too stale to accept
+func inputTime(msg ClientMessage) (time.Time, bool) {
+	now := time.Now()
+	at := now
+	if msg.T != 0 {
+		at = time.UnixMilli(msg.T)
+	}
+	return at, at.Sub(now) <= MaxInputLead && now.Sub(at) <= InputLateness
+}
+
+// Queue a one-off velocity change for the next tick, capped and rate limited
+func handleImpulse(client *Client, msg ClientMessage) bool {
+	if !isFinite(msg.DX) || !isFinite(msg.DY) {
+		log.Println("Message error: invalid impulse")
+		return false
+	}
+	dv := clampMagnitude(Vector2{X: msg.DX, Y: msg.DY}, MaxImpulse)
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	now := time.Now()
+	if now.Sub(client.lastImpulse) < ImpulseCooldown {
+		log.Println("Message error: impulse cooldown")
+		return false
+	}
+	if outOfFuel(&client.Entity) {
+		log.Println("Message error: out of fuel")
+		return false
+	}
+	client.lastImpulse = now
+	client.impulse = dv
+	return true
+}
+
+// Thrust acceleration requested by a message, clamped to the maximum
+func thrustOf(msg ClientMessage) Vector2 {
+	return clampMagnitude(Vector2{X: msg.X, Y: msg.Y}, MaxThrust)
+}
+
+// Subscribe the client to a snapshot rate, rounded to a whole number of ticks
+func handleRate(client *Client, msg ClientMessage) bool {
+	if !isFinite(msg.Hz) || msg.Hz < 1 || msg.Hz > TickRate {
+		log.Println("Message error: rate must be between 1 and", TickRate, "Hz")
+		return false
+	}
+	every := uint64(math.Round(TickRate / msg.Hz))
+
+	clientsMu.Lock()
+	client.snapshotEvery = every
+	clientsMu.Unlock()
+	return true
+}
+
+// Send the latest full snapshot to one client at once, bypassing its rate
+// subscription. Snapshots are always full state, the envelope tick tells the
+// client where it rejoins the stream.
+func handleResync(client *Client) bool {
+	// The next delta carries every field, whatever the client lost
+	clientsMu.Lock()
+	client.sent = nil
+	clientsMu.Unlock()
+	data := lastSnapshot.Load()
+	if data == nil {
+		return true
+	}
+	countMessages("out", "snapshot", len(*data))
+	client.enqueue(*data, nil)
+	return true
+}
+
+// Apply the player's join details
+func handleJoin(client *Client, msg ClientMessage) bool {
+	if len(msg.Meta) > MaxMetaSize {
+		log.Println("Message error: meta exceeds", MaxMetaSize, "bytes")
+		return false
+	}
+	name := strings.TrimSpace(msg.Name)
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		log.Println("Message error: name exceeds", MaxNameLength, "characters")
+		return false
+	}
+
+	if err := validShape(msg.Shape, msg.Length); err != nil {
+		logThrottled("Message error:", err)
+		return false
+	}
+
+	// A launch velocity replaces the computed orbit, absent keeps it
+	var launch *Vector2
+	if msg.VX != nil || msg.VY != nil {
+		v := Vector2{}
+		if msg.VX != nil {
+			v.X = *msg.VX
+		}
+		if msg.VY != nil {
+			v.Y = *msg.VY
+		}
+		if !isFinite(v.X) || !isFinite(v.Y) {
+			log.Println("Message error: invalid launch velocity")
+			return false
+		}
+		v = clampMagnitude(v, MaxLaunchSpeed)
+		launch = &v
+	}
+
+	clientsMu.Lock()
+	// Only the first join sets meta and launch velocity, later ones are
+	// renames and rate limited
+	if client.joined {
+		if time.Since(client.lastRename) < RenameCooldown {
+			clientsMu.Unlock()
+			log.Println("Message error: rename cooldown")
+			return false
+		}
+		client.lastRename = time.Now()
+	} else {
+		client.joined = true
+		client.lastRename = time.Now()
+		client.Entity.Meta = msg.Meta
+		client.Entity.Shape, client.Entity.Length = msg.Shape, msg.Length
+		if launch != nil {
+			client.Entity.Velocity = *launch
+		}
+	}
+	if name != "" {
+		client.Entity.Name = uniqueName(name, &client.Entity)
+		saveProfile(client)
+	}
+	client.send(WelcomeMessage{Envelope: envelope("welcome"), ID: client.Entity.ID, Name: client.Entity.Name})
+	notify("join", map[string]string{"id": client.Entity.ID, "name": client.Entity.Name})
+	clientsMu.Unlock()
+	return true
+}
+
+// Suffix a name with a number if another entity already uses it, the caller
+// must hold clientsMu
+func uniqueName(name string, self *Entity) string {
+	taken := make(map[string]bool)
+	for _, entity := range bodies() {
+		if entity != self && entity.Name != "" {
+			taken[entity.Name] = true
+		}
+	}
+	candidate := name
+	for n := 2; taken[candidate]; n++ {
+		candidate = fmt.Sprintf("%s(%d)", name, n)
+	}
+	return candidate
+}
+
+// Buffer an input in timestamp order, the caller must hold clientsMu
+func (c *Client) queueInput(input ThrustInput) {
+	i := sort.Search(len(c.inputs), func(i int) bool { return c.inputs[i].At.After(input.At) })
+	c.inputs = append(c.inputs, ThrustInput{})
+	copy(c.inputs[i+1:], c.inputs[i:])
+	c.inputs[i] = input
+	if len(c.inputs) > MaxQueuedInput {
+		c.inputs = c.inputs[len(c.inputs)-MaxQueuedInput:]
+	}
+}
+
+// Apply the most recent input due by now and keep later ones buffered, the
+// caller must hold clientsMu. The requested thrust persists until replaced,
+// or for one tick from the untyped form, and with smoothing on the applied thrust eases toward it over a few ticks.
+func (c *Client) applyInputs(now time.Time) {
+	if c.thrustOnce {
+		c.thrustTarget, c.thrustOnce = Vector2{}, false
+	}
+	due := 0
+	for due < len(c.inputs) && !c.inputs[due].At.After(now) {
+		due++
+	}
+	if due > 0 {
+		// Manual thrust or ship controls take back control
+		if c.autopilot != "" {
+			c.endAutopilot("cancelled")
+		}
+		if input := c.inputs[due-1]; input.Helm != nil {
+			c.helm = *input.Helm
+		} else {
+			c.thrustTarget, c.thrustOnce = input.Thrust, input.Once
+			c.helm.engaged = false
+		}
+		c.inputs = c.inputs[due:]
+	}
+	if !c.Entity.alive() {
+		return
+	}
+	impulse := burnFuel(&c.Entity, c.impulse, 1)
+	c.Entity.Velocity.X += impulse.X
+	c.Entity.Velocity.Y += impulse.Y
+	c.impulse = Vector2{}
+	if c.autopilot != "" {
+		c.steerAutopilot()
+	}
+	c.steerHelm()
+
+	if config.ThrustSmoothing <= 0 {
+		c.Entity.Thrust = c.thrustTarget
+		return
+	}
+	// Low-pass filter, closing part of the gap each tick
+	k := 1 - config.ThrustSmoothing
+	thrust := &c.Entity.Thrust
+	thrust.X += (c.thrustTarget.X - thrust.X) * k
+	thrust.Y += (c.thrustTarget.Y - thrust.Y) * k
+	if math.Hypot(c.thrustTarget.X-thrust.X, c.thrustTarget.Y-thrust.Y) < ThrustSnap {
+		*thrust = c.thrustTarget
+	}
+}
+
+// ThrustFxEvent tells every viewer to draw exhaust for a thrusting entity
+type ThrustFxEvent struct {
+	Envelope
+	ID  string  `json:"id"`
+	Dir float64 `json:"dir"` // Thrust direction in radians, exhaust points the opposite way
+}
+
+// Emit a rate-limited exhaust event while the entity is thrusting, the caller
+// must hold clientsMu
+func (c *Client) emitThrustFx(now time.Time) {
+	thrust := c.Entity.Thrust
+	if (thrust.X == 0 && thrust.Y == 0) || now.Sub(c.lastThrustFx) < ThrustFxInterval {
+		return
+	}
+	c.lastThrustFx = now
+	emitEvent(ThrustFxEvent{Envelope: envelope("thrust_fx"), ID: c.Entity.ID, Dir: math.Atan2(thrust.Y, thrust.X)})
+}
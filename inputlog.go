@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InputRecord is one line of the input log: either a parsed command and
+// whether its handler accepted it, or the thrust acceleration the physics
+// applied to the entity when that changes
+type InputRecord struct {
+	Time     time.Time      `json:"time"`
+	ID       string         `json:"id"`
+	Tick     uint64         `json:"tick,omitempty"` // Tick the thrust was applied in
+	Message  *ClientMessage `json:"message,omitempty"`
+	Accepted *bool          `json:"accepted,omitempty"`
+	Applied  *Vector2       `json:"applied,omitempty"`
+}
+
+// Input log writer, nil when recording is disabled
+var (
+	inputLog    *json.Encoder
+	inputLogIDs map[string]bool // Empty records every connection
+	inputLogMu  sync.Mutex
+)
+
+// Open the input log if one is configured
+func openInputLog() {
+	if config.InputLog == "" {
+		return
+	}
+	f, err := os.OpenFile(config.InputLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Fatal("Input log error:", err)
+	}
+	inputLog = json.NewEncoder(f)
+	inputLogIDs = make(map[string]bool)
+	for _, id := range strings.Split(config.InputLogIDs, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			inputLogIDs[id] = true
+		}
+	}
+}
+
+// Report whether the input log records the entity
+func loggingInput(id string) bool {
+	return inputLog != nil && (len(inputLogIDs) == 0 || inputLogIDs[id])
+}
+
+// Append a handled command to the input log as NDJSON
+func recordInput(id string, msg ClientMessage, accepted bool) {
+	if loggingInput(id) {
+		writeInputRecord(InputRecord{Time: time.Now(), ID: id, Message: &msg, Accepted: &accepted})
+	}
+}
+
+// Append the thrust the last step applied to the client's entity, when it
+// differs from the last one logged. The caller must hold clientsMu.
+func (c *Client) recordApplied() {
+	applied := c.Entity.appliedThrust
+	if !c.Entity.alive() {
+		applied = Vector2{}
+	}
+	if applied == c.loggedThrust || !loggingInput(c.Entity.ID) {
+		return
+	}
+	c.loggedThrust = applied
+	writeInputRecord(InputRecord{Time: time.Now(), ID: c.Entity.ID, Tick: world.tick, Applied: &applied})
+}
+
+// Write one input log line
+func writeInputRecord(record InputRecord) {
+	inputLogMu.Lock()
+	defer inputLogMu.Unlock()
+	if err := inputLog.Encode(record); err != nil {
+		log.Println("Input log error:", err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// Linking limits
+const (
+	LinkRange    = 20 // Largest gap between surfaces of entities that may link
+	MaxGroupSize = 8
+)
+
+// linkGroup is a rigid constellation of entities moving as one body
+type linkGroup struct {
+	members []*Entity
+	offsets []Vector2 // Fixed offset of each member from the group's centre of mass
+}
+
+// Linked groups keyed by group ID, and the counter group IDs are drawn from
+// so no two groups ever share one. Guarded by clientsMu.
+var (
+	groups   = make(map[string]*linkGroup)
+	groupSeq uint64
+)
+
+// LinkEvent is sent when an entity joins a group
+type LinkEvent struct {
+	Envelope
+	A     string `json:"a"` // Entity that asked to link
+	B     string `json:"b"`
+	Group string `json:"group"`
+}
+
+// UnlinkEvent is sent when an entity leaves a group
+type UnlinkEvent struct {
+	Envelope
+	ID    string `json:"id"`
+	Group string `json:"group"`
+}
+
+// Report whether two entities belong to the same group
+func linked(a, b *Entity) bool {
+	return a.Group != "" && a.Group == b.Group
+}
+
+// Link the client's entity to a nearby entity, merging any groups either
+// already belongs to
+func handleLink(client *Client, msg ClientMessage) bool {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	a := &client.Entity
+	b := lookupEntity(msg.Target)
+	switch {
+	case client.Spectator || !a.alive():
+		return false
+	case b == nil || b == a || !b.alive() || b.Static || b.Owner != "":
+		log.Println("Message error: cannot link to", msg.Target)
+		return false
+	case linked(a, b):
+		return false
+	}
+	d := displacement(a.Position, b.Position)
+	if math.Hypot(d.X, d.Y)-a.Radius-b.Radius > LinkRange {
+		log.Println("Message error: link target out of range")
+		return false
+	}
+	members := append(groupMembers(a), groupMembers(b)...)
+	if len(members) > MaxGroupSize {
+		log.Println("Message error: group would exceed", MaxGroupSize, "entities")
+		return false
+	}
+
+	groupSeq++
+	id := fmt.Sprintf("group-%d", groupSeq)
+	if groups[id] != nil {
+		log.Println("Message error: group", id, "already exists")
+		return false
+	}
+	for _, e := range members {
+		if e.Group != "" {
+			delete(groups, e.Group)
+		}
+	}
+	for _, e := range members {
+		e.Group = id
+	}
+	g := &linkGroup{members: members}
+	groups[id] = g
+	g.reform()
+	g.rigidify()
+	emitEvent(LinkEvent{Envelope: envelope("link"), A: a.ID, B: b.ID, Group: id})
+	return true
+}
+
+// Detach the client's entity from its group
+func handleUnlink(client *Client) bool {
+	clientsMu.Lock()
+	detach(&client.Entity)
+	clientsMu.Unlock()
+	return true
+}
+
+// Total mass of an entity's group, or its own mass when it is not linked
+func groupMass(e *Entity) float64 {
+	if e.Group == "" {
+		return e.Mass
+	}
+	g := groups[e.Group]
+	if g == nil {
+		return e.Mass
+	}
+	mass := 0.0
+	for _, m := range g.members {
+		mass += m.Mass
+	}
+	return mass
+}
+
+// Members of an entity's group, or just the entity when it is not linked
+func groupMembers(e *Entity) []*Entity {
+	if g := groups[e.Group]; g != nil {
+		return append([]*Entity(nil), g.members...)
+	}
+	return []*Entity{e}
+}
+
+// Take an entity out of its group, the caller must hold clientsMu
+func detach(e *Entity) {
+	id := e.Group
+	if groups[id] == nil {
+		return
+	}
+	e.Group = ""
+	emitEvent(UnlinkEvent{Envelope: envelope("unlink"), ID: e.ID, Group: id})
+	leave(id, e)
+}
+
+// Remove a member from a group, dissolving the group when one member is left
+func leave(id string, e *Entity) {
+	g := groups[id]
+	kept := g.members[:0]
+	for _, m := range g.members {
+		if m != e {
+			kept = append(kept, m)
+		}
+	}
+	g.members = kept
+	if len(kept) >= 2 {
+		g.reform()
+		return
+	}
+	for _, m := range kept {
+		m.Group = ""
+		emitEvent(UnlinkEvent{Envelope: envelope("unlink"), ID: m.ID, Group: id})
+	}
+	delete(groups, id)
+}
+
+// Centre of mass and total momentum of the group's members
+func (g *linkGroup) centre() (Vector2, Vector2, float64) {
+	ref := g.members[0].Position
+	var sum, momentum Vector2
+	mass := 0.0
+	for _, m := range g.members {
+		d := displacement(ref, m.Position)
+		sum.X += m.Mass * d.X
+		sum.Y += m.Mass * d.Y
+		momentum.X += m.Mass * m.Velocity.X
+		momentum.Y += m.Mass * m.Velocity.Y
+		mass += m.Mass
+	}
+	return Vector2{X: ref.X + sum.X/mass, Y: ref.Y + sum.Y/mass}, momentum, mass
+}
+
+// Fix each member's offset from the centre of mass at its current position
+func (g *linkGroup) reform() {
+	com, _, _ := g.centre()
+	g.offsets = make([]Vector2, len(g.members))
+	for i, m := range g.members {
+		g.offsets[i] = displacement(com, m.Position)
+	}
+}
+
+// Move every member with the group's centre of mass, sharing its momentum,
+// so forces on any member act on the group as a whole
+func (g *linkGroup) rigidify() {
+	com, momentum, mass := g.centre()
+	v := Vector2{X: momentum.X / mass, Y: momentum.Y / mass}
+	for i, m := range g.members {
+		m.Position = Vector2{X: com.X + g.offsets[i].X, Y: com.Y + g.offsets[i].Y}
+		if config.Wrap {
+			m.Position = wrapPosition(m.Position)
+		}
+		m.Velocity = v
+	}
+}
+
+// Restore every group to a rigid body after members moved or collided on
+// their own, detaching members that died or left the simulation. The caller
+// must hold clientsMu.
+func rigidifyGroups(all []*Entity) {
+	if len(groups) == 0 {
+		return
+	}
+	present := make(map[*Entity]bool, len(all))
+	for _, e := range all {
+		present[e] = true
+	}
+	for id, g := range groups {
+		for _, m := range append([]*Entity(nil), g.members...) {
+			if groups[id] == nil {
+				break
+			}
+			switch {
+			case m.Group != id:
+				// The slot now holds a different entity, such as a promoted spectator
+				leave(id, m)
+			case !present[m] || !m.alive():
+				detach(m)
+			}
+		}
+	}
+	for _, g := range groups {
+		g.rigidify()
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Window over which repeats of a hot-path log line are coalesced
+const LogWindow = time.Second
+
+// Repeats suppressed in the current window by line prefix, with the latest
+// message of each, guarded by logMu
+var (
+	logRepeats = make(map[string]int)
+	logLatest  = make(map[string]string)
+	logMu      sync.Mutex
+	logFlusher sync.Once
+)
+
+// Log like log.Println, but only the first line for a prefix in each window.
+// The rest are counted and summarized once the window ends, so an outage
+// failing every client at once stays readable.
+func logThrottled(prefix string, v ...any) {
+	msg := fmt.Sprintln(append([]any{prefix}, v...)...)
+	logFlusher.Do(func() { go flushLogRepeats() })
+
+	logMu.Lock()
+	n, seen := logRepeats[prefix]
+	if seen {
+		logRepeats[prefix] = n + 1
+		logLatest[prefix] = msg
+	} else {
+		logRepeats[prefix] = 0
+	}
+	logMu.Unlock()
+	if !seen {
+		log.Print(msg)
+	}
+}
+
+// Summarize each window's suppressed repeats and start a fresh window
+func flushLogRepeats() {
+	ticker := time.NewTicker(LogWindow)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		logMu.Lock()
+		repeats, latest := logRepeats, logLatest
+		logRepeats, logLatest = make(map[string]int), make(map[string]string)
+		logMu.Unlock()
+
+		prefixes := make([]string, 0, len(repeats))
+		for prefix, n := range repeats {
+			if n > 0 {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+		sort.Strings(prefixes)
+		for _, prefix := range prefixes {
+			log.Printf("%s x%d more in the last %v, latest: %s", prefix, repeats[prefix], LogWindow, latest[prefix])
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Message is any outgoing message, all of which embed an Envelope
+type Message interface {
+	messageType() string
+}
+
+// Type of the message the envelope heads
+func (e Envelope) messageType() string {
+	return e.Type
+}
+
+// Message counters by direction and type, and disconnect counters by close
+// code, exposed in Prometheus text format
+var (
+	messageCounts   = make(map[[2]string]uint64)
+	closeCounts     = make(map[string]uint64)
+	messageCountsMu sync.Mutex
+)
+
+// Count n messages of a type in a direction ("in" or "out")
+func countMessages(direction, messageType string, n int) {
+	if n <= 0 {
+		return
+	}
+	messageCountsMu.Lock()
+	messageCounts[[2]string{direction, messageType}] += uint64(n)
+	messageCountsMu.Unlock()
+}
+
+// Requests served by HTTP protocol version, guarded by messageCountsMu
+var protocolCounts = make(map[string]uint64)
+
+// Count every request by protocol version before handing it on
+func countProtocols(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		messageCountsMu.Lock()
+		protocolCounts[r.Proto]++
+		messageCountsMu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Copy of the per-protocol request counts
+func protocolSnapshot() map[string]uint64 {
+	messageCountsMu.Lock()
+	defer messageCountsMu.Unlock()
+	counts := make(map[string]uint64, len(protocolCounts))
+	for proto, n := range protocolCounts {
+		counts[proto] = n
+	}
+	return counts
+}
+
+// Count a disconnect by close code, "error" when the connection failed
+// without a close frame
+func countClose(code string) {
+	messageCountsMu.Lock()
+	closeCounts[code]++
+	messageCountsMu.Unlock()
+}
+
+// Serve counters in the Prometheus text exposition format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	messageCountsMu.Lock()
+	keys := make([][2]string, 0, len(messageCounts))
+	for key := range messageCounts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	counts := make([]uint64, len(keys))
+	for i, key := range keys {
+		counts[i] = messageCounts[key]
+	}
+	codes := make([]string, 0, len(closeCounts))
+	for code := range closeCounts {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	closes := make([]uint64, len(codes))
+	for i, code := range codes {
+		closes[i] = closeCounts[code]
+	}
+	messageCountsMu.Unlock()
+
+	clientsMu.Lock()
+	players := playerCount()
+	spectators := len(clients) - players
+	peaks := [2]int{peakPlayers, peakSpectators}
+	clientsMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP space_web_messages_total WebSocket messages by direction and type.")
+	fmt.Fprintln(w, "# TYPE space_web_messages_total counter")
+	for i, key := range keys {
+		fmt.Fprintf(w, "space_web_messages_total{direction=%q,type=%q} %d\n", key[0], key[1], counts[i])
+	}
+	fmt.Fprintln(w, "# HELP space_web_disconnects_total Client disconnects by websocket close code, error or slow_reader.")
+	fmt.Fprintln(w, "# TYPE space_web_disconnects_total counter")
+	for i, code := range codes {
+		fmt.Fprintf(w, "space_web_disconnects_total{code=%q} %d\n", code, closes[i])
+	}
+	fmt.Fprintln(w, "# HELP space_web_missed_ticks_total Physics ticks dropped because the loop fell behind.")
+	fmt.Fprintln(w, "# TYPE space_web_missed_ticks_total counter")
+	fmt.Fprintf(w, "space_web_missed_ticks_total %d\n", world.missed.Load())
+	fmt.Fprintln(w, "# HELP space_web_clients Connected clients by role.")
+	fmt.Fprintln(w, "# TYPE space_web_clients gauge")
+	fmt.Fprintf(w, "space_web_clients{role=\"player\"} %d\n", players)
+	fmt.Fprintf(w, "space_web_clients{role=\"spectator\"} %d\n", spectators)
+	fmt.Fprintln(w, "# HELP space_web_peak_clients Most clients connected at once since startup, by role.")
+	fmt.Fprintln(w, "# TYPE space_web_peak_clients gauge")
+	fmt.Fprintf(w, "space_web_peak_clients{role=\"player\"} %d\n", peaks[0])
+	fmt.Fprintf(w, "space_web_peak_clients{role=\"spectator\"} %d\n", peaks[1])
+}
@@ -0,0 +1,95 @@
+package main
+
+import "math"
+
+// OrbitalElements describe an entity's Keplerian orbit around the star
+type OrbitalElements struct {
+	SemiMajorAxis float64 `json:"semiMajorAxis"` // Negative for unbound orbits
+	Eccentricity  float64 `json:"eccentricity"`
+	Period        float64 `json:"period"` // Seconds, infinite for unbound orbits
+	Energy        float64 `json:"energy"` // Specific orbital energy
+	Bound         bool    `json:"bound"`
+}
+
+// Compute the two-body orbit of an entity around the star, treating it as a
+// test particle
+func orbitalElements(entity Entity) OrbitalElements {
+	mu := G * starMass() * world.gravityMultiplier
+	d := displacement(starPosition(), entity.Position)
+	r := math.Max(math.Hypot(d.X, d.Y), 0.1) // Prevent division by zero
+	v2 := entity.Velocity.X*entity.Velocity.X + entity.Velocity.Y*entity.Velocity.Y
+	if mu == 0 {
+		// Without a star nothing is bound and there is no orbit to describe
+		return OrbitalElements{Energy: v2 / 2, Period: math.Inf(1)}
+	}
+
+	energy := v2/2 - mu/r
+	h := d.X*entity.Velocity.Y - d.Y*entity.Velocity.X // Specific angular momentum
+	e := math.Sqrt(math.Max(0, 1+2*energy*h*h/(mu*mu)))
+
+	elements := OrbitalElements{Eccentricity: e, Energy: energy, Period: math.Inf(1)}
+	if energy < 0 {
+		elements.Bound = true
+		elements.SemiMajorAxis = -mu / (2 * energy)
+		elements.Period = 2 * math.Pi * math.Sqrt(math.Pow(elements.SemiMajorAxis, 3)/mu)
+	} else if energy > 0 {
+		elements.SemiMajorAxis = -mu / (2 * energy)
+	}
+	return elements
+}
+
+// Report whether an entity has left the world on an orbit that will never
+// bring it back: outside the boundary, unbound and moving away from the star
+func escaped(entity *Entity) bool {
+	if math.Abs(entity.Position.X) <= WorldWidth/2 && math.Abs(entity.Position.Y) <= WorldHeight/2 {
+		return false
+	}
+	if orbitalElements(*entity).Bound {
+		return false
+	}
+	d := displacement(starPosition(), entity.Position)
+	return d.X*entity.Velocity.X+d.Y*entity.Velocity.Y > 0
+}
+
+// Remove escaped bots and respawn escaped players, the caller must hold
+// clientsMu
+func despawnEscaped() {
+	if !config.DespawnUnbound {
+		return
+	}
+	for id, bot := range bots {
+		if bot.alive() && escaped(bot) {
+			delete(bots, id)
+			emitEvent(LeaveEvent{Envelope: envelope("leave"), ID: id})
+		}
+	}
+	for client := range clients {
+		if !client.Spectator && client.Entity.alive() && escaped(&client.Entity) {
+			world.kill(&client.Entity)
+		}
+	}
+}
+
+// Accumulate the change in polar angle around the star into the entity's
+// phase. Steps are far shorter than half an orbit, so a jump of more than π
+// is the angle wrapping between +π and -π rather than real motion. The phase
+// is deliberately left unwrapped: a float64 still resolves a tenth of a
+// microradian after a billion radians, years of orbits for the fastest
+// entities, and it starts over at each respawn.
+func trackPhase(entity *Entity) {
+	if config.NoStar {
+		return
+	}
+	d := displacement(starPosition(), entity.Position)
+	angle := math.Atan2(d.Y, d.X)
+	if entity.phaseSet {
+		delta := angle - entity.angle
+		if delta > math.Pi {
+			delta -= 2 * math.Pi
+		} else if delta < -math.Pi {
+			delta += 2 * math.Pi
+		}
+		entity.Phase += delta
+	}
+	entity.angle, entity.phaseSet = angle, true
+}
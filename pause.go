@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Time between snapshots while paused, enough for late joiners to see the
+// frozen state
+const PausedSnapshotInterval = time.Second
+
+// Whether physics is paused and when the last paused snapshot went out,
+// guarded by clientsMu
+var (
+	paused          bool
+	lastPausedFrame time.Time
+)
+
+// Report whether a snapshot is due this tick while paused, the caller must
+// hold clientsMu
+func pausedSnapshotDue(now time.Time) bool {
+	if now.Sub(lastPausedFrame) < PausedSnapshotInterval {
+		return false
+	}
+	lastPausedFrame = now
+	return true
+}
+
+// Pause or resume physics. Snapshots carry the state, and one goes out on the
+// next tick so clients see the change at once.
+func pauseHandler(pause bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientsMu.Lock()
+		paused = pause
+		lastPausedFrame = time.Time{}
+		clientsMu.Unlock()
+		writeJSON(w, map[string]bool{"paused": pause})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// Velocity-squared drag from the atmosphere near the star. Density falls
+// quadratically from 1 at the star surface to 0 at the atmosphere altitude.
+func atmosphericDrag(entity *Entity) Vector2 {
+	if config.NoStar || config.AtmosphereAltitude <= 0 || config.AtmosphereDrag <= 0 {
+		return Vector2{}
+	}
+	d := displacement(starPosition(), entity.Position)
+	altitude := math.Hypot(d.X, d.Y) - config.StarRadius
+	if altitude >= config.AtmosphereAltitude {
+		return Vector2{}
+	}
+	density := 1 - math.Max(altitude, 0)/config.AtmosphereAltitude
+	density *= density
+
+	speed := math.Hypot(entity.Velocity.X, entity.Velocity.Y)
+	k := -config.AtmosphereDrag * density * speed
+	return Vector2{X: k * entity.Velocity.X, Y: k * entity.Velocity.Y}
+}
+
+// Scale a thrust or impulse down to the fuel left and burn it. Fuel is a
+// delta-v budget, so v applied over dt costs |v| dt, and an impulse is dt 1.
+func burnFuel(entity *Entity, v Vector2, dt float64) Vector2 {
+	if config.FuelCapacity <= 0 {
+		return v
+	}
+	cost := math.Hypot(v.X, v.Y) * dt
+	if cost > entity.Fuel {
+		k := entity.Fuel / cost
+		v = Vector2{X: v.X * k, Y: v.Y * k}
+		cost = entity.Fuel
+	}
+	entity.Fuel -= cost
+	return v
+}
+
+// Acceleration from an entity's thrust. As a force it is divided by the
+// current mass, which shrinks as fuel burns when fuel has mass.
+func thrustAccel(entity *Entity) Vector2 {
+	if !config.ThrustForce {
+		return entity.Thrust
+	}
+	mass := entity.Mass + entity.Fuel*config.FuelMass
+	return Vector2{X: entity.Thrust.X / mass, Y: entity.Thrust.Y / mass}
+}
+
+// Expel the propellant for thrust accel applied over dt. Under the rocket
+// equation mass falls by a factor of e for every exhaust speed of delta-v,
+// and never below the dry mass.
+func expelMass(entity *Entity, accel Vector2, dt float64) {
+	if config.ExhaustSpeed <= 0 || entity.Mass <= config.DryMass {
+		return
+	}
+	dv := math.Hypot(accel.X, accel.Y) * dt
+	loss := math.Min(entity.Mass*-math.Expm1(-dv/config.ExhaustSpeed), entity.Mass-config.DryMass)
+	entity.Mass -= loss
+	entity.burned += loss
+	entity.Radius = entityRadius(entity.Mass)
+}
+
+// Regenerate fuel over dt seconds, up to capacity
+func regenFuel(entity *Entity, dt float64) {
+	if config.FuelCapacity <= 0 {
+		return
+	}
+	entity.Fuel = math.Min(config.FuelCapacity, entity.Fuel+config.FuelRegen*dt)
+}
+
+// Report whether an entity has run out of fuel, never when fuel is disabled
+func outOfFuel(entity *Entity) bool {
+	return config.FuelCapacity > 0 && entity.Fuel <= 0
+}
+
+// Clamp speed to a fraction of the local escape velocity. This is not
+// physical, it keeps every entity bound for a contained arena. The caller
+// must hold w.mu.
+func (w *World) containSpeed(entity *Entity) {
+	if config.NoStar || config.EscapeSpeedFraction <= 0 {
+		return
+	}
+	if config.GravityExponent <= 1 {
+		return // Nothing escapes a force that falls off this slowly
+	}
+	escape := math.Sqrt(-2 * w.starPotential(entity.Position))
+	entity.Velocity = clampMagnitude(entity.Velocity, config.EscapeSpeedFraction*escape)
+}
+
+// Gravitational potential of the star at a point, under the world's gravity
+// multiplier. The caller must hold w.mu.
+func (w *World) starPotential(pos Vector2) float64 {
+	d := displacement(starPosition(), pos)
+	r := math.Max(math.Hypot(d.X, d.Y), 0.1) // Prevent division by zero
+	return powerPotential(G*starMass()*w.gravityMultiplier, r, 0)
+}
+
+// Potential at distance r from a point mass with gravitational parameter gm,
+// under the configured force law, with the pull held at its strength at floor
+// inside it as the acceleration is
+func powerPotential(gm, r, floor float64) float64 {
+	if r < floor {
+		return powerPotential(gm, floor, 0) - gm/inversePower(floor)*(floor-r)
+	}
+	// Integrates the force law, so inverse-square is -GM/r
+	if n := config.GravityExponent; n != 1 {
+		return -gm * r / ((n - 1) * inversePower(r))
+	}
+	return gm * math.Log(r)
+}
+
+// Potential of the world's fixed bodies at a point: the star, the planets,
+// and the gravity wells, under the gravity multiplier. The caller must hold
+// w.mu.
+func (w *World) potential(pos Vector2) float64 {
+	phi := w.starPotential(pos)
+	for _, planet := range config.Planets {
+		d := displacement(pos, planet.Position)
+		phi += powerPotential(G*planet.Mass*w.gravityMultiplier, math.Max(math.Hypot(d.X, d.Y), 0.1), planet.Radius)
+	}
+	for _, well := range w.wells {
+		d := displacement(pos, well.position)
+		phi += powerPotential(G*well.mass*w.gravityMultiplier, math.Max(math.Hypot(d.X, d.Y), 0.1), WellMinDistance)
+	}
+	return phi
+}
+
+// Potential and acceleration at a point due to entity masses
+func entityGravity(pos Vector2, all []*Entity) (float64, Vector2) {
+	var phi float64
+	var accel Vector2
+	for _, entity := range all {
+		d := displacement(pos, entity.Position)
+		r := math.Max(math.Hypot(d.X, d.Y), 0.1) // Prevent division by zero
+		phi -= G * entity.Mass / r
+		k := G * entity.Mass / (r * r * r)
+		accel.X += k * d.X
+		accel.Y += k * d.Y
+	}
+	return phi, accel
+}
+
+// Accumulate pairwise gravity between entities into their external
+// acceleration. Beyond the cutoff radius pairs are skipped, which ignores the
+// small pull of distant mass in exchange for far fewer pairs. The caller must
+// hold w.mu.
+func (w *World) accumulateNBody(all []*Entity) {
+	for _, entity := range all {
+		entity.external = Vector2{}
+	}
+	if !w.physics.NBody {
+		return
+	}
+
+	pull := func(a, b *Entity) {
+		if a == b || !b.alive() {
+			return
+		}
+		d := displacement(a.Position, b.Position)
+		// Plummer softening spreads each mass out to tame close encounters
+		r2 := d.X*d.X + d.Y*d.Y + w.physics.Softening*w.physics.Softening
+		r := math.Max(math.Sqrt(r2), 0.1) // Prevent division by zero
+		k := G * b.Mass / (r * r * r)
+		a.external.X += k * d.X
+		a.external.Y += k * d.Y
+	}
+
+	if w.physics.GravityCutoff <= 0 {
+		for _, a := range all {
+			for _, b := range all {
+				pull(a, b)
+			}
+		}
+		return
+	}
+	grid := newSpatialGrid(all)
+	for _, a := range all {
+		grid.near(a.Position, w.physics.GravityCutoff, all, func(b *Entity, _ float64) {
+			pull(a, b)
+		})
+	}
+}
+
+// MassSummary is the center of mass and total momentum of the system
+type MassSummary struct {
+	TotalMass    float64 `json:"totalMass"`
+	CenterOfMass Vector2 `json:"centerOfMass"`
+	Momentum     Vector2 `json:"momentum"`
+	EntityMass   float64 `json:"entityMass"`
+	EntityCenter Vector2 `json:"entityCenter"` // Center of mass of the entities alone
+}
+
+// Sum mass and momentum over the star and every live entity. Positions are
+// used as stored, so with wrapping on the center is only meaningful for
+// systems that do not straddle an edge. The star is fixed and carries no
+// momentum.
+func massSummary(all []*Entity) MassSummary {
+	var s MassSummary
+	for _, entity := range all {
+		if !entity.alive() {
+			continue
+		}
+		s.EntityMass += entity.Mass
+		s.EntityCenter.X += entity.Mass * entity.Position.X
+		s.EntityCenter.Y += entity.Mass * entity.Position.Y
+		s.Momentum.X += entity.Mass * entity.Velocity.X
+		s.Momentum.Y += entity.Mass * entity.Velocity.Y
+	}
+	star := starPosition()
+	s.TotalMass = s.EntityMass + starMass()
+	if s.TotalMass > 0 {
+		s.CenterOfMass = Vector2{
+			X: (s.EntityCenter.X + starMass()*star.X) / s.TotalMass,
+			Y: (s.EntityCenter.Y + starMass()*star.Y) / s.TotalMass,
+		}
+	}
+	if s.EntityMass > 0 {
+		s.EntityCenter.X /= s.EntityMass
+		s.EntityCenter.Y /= s.EntityMass
+	}
+	return s
+}
+
+// Total angular momentum of every live entity about the star, the sum of
+// m (r x v) with r measured from the star, positive counterclockwise
+func angularMomentum(all []*Entity) float64 {
+	star := starPosition()
+	total := 0.0
+	for _, entity := range all {
+		if !entity.alive() {
+			continue
+		}
+		r := displacement(star, entity.Position)
+		total += entity.Mass * (r.X*entity.Velocity.Y - r.Y*entity.Velocity.X)
+	}
+	return total
+}
+
+// Report whether an entity's position is beyond the coordinate limit, or its
+// position or velocity is not a finite number, which only a physics fault can
+// cause. Non-finite state is caught even with the limit disabled, since it
+// would otherwise spread to every entity it touches.
+func outOfBounds(entity *Entity) bool {
+	p, v := entity.Position, entity.Velocity
+	if !isFinite(p.X) || !isFinite(p.Y) || !isFinite(v.X) || !isFinite(v.Y) {
+		return true
+	}
+	return config.MaxCoordinate > 0 &&
+		(math.Abs(p.X) > config.MaxCoordinate || math.Abs(p.Y) > config.MaxCoordinate)
+}
+
+// Time between tunneling warnings, which summarize the ticks in between
+const TunnelWarnInterval = 10 * time.Second
+
+// Warn, at most once per interval for the world, when an entity moved far
+// enough in one tick relative to its size to pass through another without
+// colliding. Projectiles are fast by design and not reported. The caller must
+// hold w.mu.
+func (w *World) checkTunneling(entity *Entity, before Vector2) {
+	if config.TunnelFraction <= 0 || entity.Owner != "" {
+		return
+	}
+	d := displacement(before, entity.Position)
+	moved := math.Hypot(d.X, d.Y)
+	if moved <= config.TunnelFraction*entity.Radius {
+		return
+	}
+	w.tunnelSteps++
+	now := time.Now()
+	if now.Sub(w.lastTunnelWarn) < TunnelWarnInterval {
+		return
+	}
+	log.Printf("Warning: entity %s moved %.3g in one tick, %.2g of its radius, collisions may tunnel (%d such steps since the last warning); lower -time-scale or the speed",
+		entity.ID, moved, moved/entity.Radius, w.tunnelSteps)
+	w.tunnelSteps = 0
+	w.lastTunnelWarn = now
+}
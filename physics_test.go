@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Limits for the circular orbit test. Semi-implicit Euler is symplectic, so
+// energy oscillates within a bound instead of drifting away.
+const (
+	OrbitTestRadius          = 50
+	OrbitTestSteps           = 10000
+	OrbitTestEnergyTolerance = 0.01 // Relative to the starting energy
+	OrbitTestRadiusTolerance = 0.05 // Relative to the starting radius
+)
+
+func TestCircularOrbitConservesEnergy(t *testing.T) {
+	star := starPosition()
+	entity := Entity{
+		Position: Vector2{X: star.X + OrbitTestRadius, Y: star.Y},
+		Velocity: Vector2{Y: calculateOrbitalVelocity(starMass(), OrbitTestRadius)},
+		Mass:     1,
+		Radius:   entityRadius(1),
+		State:    StateAlive,
+	}
+	start := specificEnergy(entity)
+
+	worstEnergy, worstRadius := 0.0, 0.0
+	for i := 0; i < OrbitTestSteps; i++ {
+		world.step(&entity)
+		d := displacement(star, entity.Position)
+		worstRadius = math.Max(worstRadius, math.Abs(math.Hypot(d.X, d.Y)-OrbitTestRadius)/OrbitTestRadius)
+		worstEnergy = math.Max(worstEnergy, math.Abs((specificEnergy(entity)-start)/start))
+	}
+	t.Logf("worst energy error %.3g, worst radius error %.3g", worstEnergy, worstRadius)
+	if worstEnergy > OrbitTestEnergyTolerance {
+		t.Errorf("energy strayed %.3g from its start, above %g", worstEnergy, OrbitTestEnergyTolerance)
+	}
+	if worstRadius > OrbitTestRadiusTolerance {
+		t.Errorf("radius strayed %.3g from its start, above %g", worstRadius, OrbitTestRadiusTolerance)
+	}
+}
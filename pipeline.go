@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// tickSpan is the part of a tick the slow tick warning counts a stage in
+type tickSpan int
+
+const (
+	spanIntegration tickSpan = iota
+	spanCollision
+	spanOther
+)
+
+// tickStage is one step of the main world's tick. runTick runs the stages in
+// table order and the pipeline endpoint reports the same table, so the two
+// cannot drift apart.
+type tickStage struct {
+	name    string
+	enabled func() bool   // Whether the configuration turns the stage on, nil when always
+	detail  func() string // Parameters worth reporting, nil when none
+	run     func(now time.Time)
+	span    tickSpan
+
+	// Per-entity steps the stage runs, reported in the order integrate and
+	// stepFor apply them. Keep these in step with those functions.
+	steps []tickStage
+}
+
+// Stages of the main world's tick, after the tick counter and gravity ramp
+// advance. Assigned in init since the stages refer back to the world.
+var tickStages []tickStage
+
+func init() {
+	tickStages = []tickStage{
+		{name: "god entity", run: func(time.Time) { applyGod() }},
+		{
+			name:    "sleep",
+			enabled: func() bool { return config.SleepStride > 1 },
+			detail:  func() string { return fmt.Sprintf("stride %d, radius %g", config.SleepStride, config.SleepRadius) },
+			run:     func(time.Time) { updateSleep(bodies()) },
+		},
+		{
+			name:    "nbody",
+			enabled: func() bool { return config.NBody },
+			detail:  func() string { return fmt.Sprintf("cutoff %g, softening %g", config.GravityCutoff, config.Softening) },
+			run:     func(time.Time) { world.accumulateNBody(bodies()) },
+		},
+		{name: "entities", run: integrateEntities, steps: entitySteps()},
+		{name: "bot expiry", run: pruneBots},
+		{name: "projectile expiry", run: pruneProjectiles},
+		{name: "well expiry", run: pruneWells},
+		{name: "link rigidify", run: func(time.Time) { rigidifyGroups(bodies()) }},
+		{
+			name:    "rounds",
+			enabled: func() bool { return config.RoundDuration > 0 },
+			detail:  func() string { return config.RoundDuration.String() },
+			run:     advanceRound,
+		},
+		{
+			name:    "unbound despawn",
+			enabled: func() bool { return config.DespawnUnbound },
+			run:     func(time.Time) { despawnEscaped() },
+		},
+		{
+			name:    "refuel zones",
+			enabled: func() bool { return len(config.RefuelZones) > 0 },
+			detail:  func() string { return fmt.Sprintf("%d zones", len(config.RefuelZones)) },
+			run:     func(time.Time) { updateZones(bodies()) },
+		},
+		{
+			name: "collision",
+			detail: func() string {
+				return fmt.Sprintf("%s, restitution %g, %d passes", collisionMode(), config.Restitution, config.CollisionIterations)
+			},
+			run:  func(time.Time) { world.resolveCollisions(bodies()) },
+			span: spanCollision,
+		},
+		{name: "link rigidify", run: func(time.Time) { rigidifyGroups(bodies()) }, span: spanCollision},
+		{
+			name:    "resonance",
+			enabled: func() bool { return config.Resonance },
+			run:     func(time.Time) { detectResonances(bodies()) },
+			span:    spanOther,
+		},
+	}
+}
+
+// Steps the entities stage applies to each entity
+func entitySteps() []tickStage {
+	return []tickStage{
+		{name: "inputs", detail: func() string { return fmt.Sprintf("players only, thrust smoothing %g", config.ThrustSmoothing) }},
+		{name: "thrust effects", detail: func() string { return "players only" }},
+		{name: "respawn", detail: func() string { return fmt.Sprintf("after %d ticks", RespawnDelay) }},
+		{
+			name:    "spawn protection",
+			enabled: func() bool { return config.CollisionDamage > 0 && config.SpawnProtection > 0 },
+			detail:  func() string { return config.SpawnProtection.String() },
+		},
+		{name: "sleep skip", enabled: func() bool { return config.SleepStride > 1 }},
+		{name: "star gravity", enabled: func() bool { return !config.NoStar }, detail: func() string { return "ramp " + config.GravityRamp }},
+		{
+			name:    "safe zone",
+			enabled: func() bool { return !config.NoStar && config.SafeRadius > 0 },
+			detail:  func() string { return fmt.Sprintf("radius %g", config.SafeRadius) },
+		},
+		{name: "gravity wells", detail: func() string { return fmt.Sprintf("up to %d", MaxWells) }},
+		{
+			name:    "planets",
+			enabled: func() bool { return len(config.Planets) > 0 },
+			detail:  func() string { return fmt.Sprintf("%d bodies", len(config.Planets)) },
+		},
+		{
+			name: "thrust",
+			detail: func() string {
+				return fmt.Sprintf("max %d, gravity ratio %g, force %v", MaxThrust, config.ThrustGravityRatio, config.ThrustForce)
+			},
+		},
+		{
+			name:    "fuel regen",
+			enabled: func() bool { return config.FuelCapacity > 0 && config.FuelRegen > 0 },
+			detail:  func() string { return fmt.Sprintf("%g/s", config.FuelRegen) },
+		},
+		{
+			name:    "fuel burn",
+			enabled: func() bool { return config.FuelCapacity > 0 },
+			detail:  func() string { return fmt.Sprintf("capacity %g", config.FuelCapacity) },
+		},
+		{
+			name:    "mass expulsion",
+			enabled: func() bool { return config.ExhaustSpeed > 0 },
+			detail: func() string {
+				return fmt.Sprintf("exhaust speed %g, dry mass %g", config.ExhaustSpeed, config.DryMass)
+			},
+		},
+		{name: "nbody pull", enabled: func() bool { return config.NBody }},
+		{name: "drag", enabled: func() bool { return !config.NoStar && config.AtmosphereAltitude > 0 && config.AtmosphereDrag > 0 }},
+		{name: "integrate", detail: func() string { return "semi-implicit euler" }},
+		{
+			name:    "speed containment",
+			enabled: func() bool { return !config.NoStar && config.EscapeSpeedFraction > 0 && config.GravityExponent > 1 },
+			detail:  func() string { return fmt.Sprintf("%g of escape velocity", config.EscapeSpeedFraction) },
+		},
+		{name: "wrap", enabled: func() bool { return config.Wrap }},
+		{
+			name:    "tunneling check",
+			enabled: func() bool { return config.TunnelFraction > 0 },
+			detail:  func() string { return fmt.Sprintf("%g of radius", config.TunnelFraction) },
+		},
+		{name: "coordinate limit", detail: func() string { return fmt.Sprintf("max %g, respawn", config.MaxCoordinate) }},
+		{name: "phase tracking", enabled: func() bool { return !config.NoStar }},
+		{name: "star impact", enabled: func() bool { return !config.NoStar }, detail: func() string { return "respawn" }},
+		{name: "planet impact", enabled: func() bool { return len(config.Planets) > 0 }, detail: func() string { return "respawn" }},
+		{name: "slingshot", enabled: func() bool { return !config.NoStar }, detail: func() string { return "players and bots" }},
+		{
+			name:    "assist ring",
+			enabled: func() bool { return config.AssistOuter > 0 },
+			detail: func() string {
+				return fmt.Sprintf("players and bots, %g to %g", config.AssistInner, config.AssistOuter)
+			},
+		},
+	}
+}
+
+// Apply inputs to and integrate every player, bot, and projectile, and
+// check the passes they made, the caller must hold clientsMu
+func integrateEntities(now time.Time) {
+	for client := range clients {
+		if client.Spectator {
+			continue
+		}
+		client.applyInputs(now)
+		client.emitThrustFx(now)
+		world.integrate(&client.Entity)
+		client.recordApplied()
+		checkSlingshot(&client.Entity)
+		checkAssist(&client.Entity)
+	}
+	for _, bot := range bots {
+		world.integrate(bot)
+		checkSlingshot(bot)
+		checkAssist(bot)
+	}
+	for _, projectile := range projectiles {
+		world.integrate(projectile)
+	}
+}
+
+// Describe a stage for the pipeline endpoint
+func (s tickStage) report() PipelineStage {
+	stage := PipelineStage{Name: s.name, Enabled: s.enabled == nil || s.enabled()}
+	if s.detail != nil {
+		stage.Detail = s.detail()
+	}
+	for _, step := range s.steps {
+		stage.Steps = append(stage.Steps, step.report())
+	}
+	return stage
+}
+
+// Per-tick physics stages in the order runTick applies them
+func pipelineStages() []PipelineStage {
+	stages := make([]PipelineStage, len(tickStages))
+	for i, stage := range tickStages {
+		stages[i] = stage.report()
+	}
+	return stages
+}
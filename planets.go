@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Most fixed bodies supported besides the star
+const MaxPlanets = 16
+
+// Planet is a fixed gravitating body besides the star, such as a planet or
+// moon. Entities that touch it crash and respawn, as they do into the star.
+type Planet struct {
+	Position Vector2 `json:"position"`
+	Mass     float64 `json:"mass"`
+	Radius   float64 `json:"radius"`
+}
+
+// planets is a repeatable flag.Value holding bodies given as x,y,mass,radius
+type planets []Planet
+
+func (p *planets) String() string {
+	parts := make([]string, len(*p))
+	for i, planet := range *p {
+		parts[i] = fmt.Sprintf("%g,%g,%g,%g", planet.Position.X, planet.Position.Y, planet.Mass, planet.Radius)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p *planets) Set(s string) error {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return fmt.Errorf("planet %q is not x,y,mass,radius", s)
+	}
+	var v [4]float64
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || !isFinite(f) {
+			return fmt.Errorf("planet %q has an invalid number", s)
+		}
+		v[i] = f
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return fmt.Errorf("planet %q needs a positive mass and radius", s)
+	}
+	if len(*p) == MaxPlanets {
+		return fmt.Errorf("at most %d planets", MaxPlanets)
+	}
+	*p = append(*p, Planet{Position: Vector2{X: v[0], Y: v[1]}, Mass: v[2], Radius: v[3]})
+	return nil
+}
+
+// Acceleration at pos from every planet, under the star's force law and the
+// world's gravity multiplier. Inside a planet its pull is held at surface
+// strength. The caller must hold w.mu.
+func (w *World) planetAccel(pos Vector2) Vector2 {
+	var accel Vector2
+	for _, planet := range config.Planets {
+		d := displacement(pos, planet.Position)
+		r := math.Hypot(d.X, d.Y)
+		if r == 0 {
+			continue
+		}
+		k := G * planet.Mass * w.gravityMultiplier / inversePower(math.Max(r, planet.Radius)) / r
+		accel.X += k * d.X
+		accel.Y += k * d.Y
+	}
+	return accel
+}
+
+// Check whether a position is inside a planet
+func hitsPlanet(pos Vector2) bool {
+	for _, planet := range config.Planets {
+		d := displacement(planet.Position, pos)
+		if math.Hypot(d.X, d.Y) < planet.Radius {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Limits for trajectory prediction, in simulated seconds
+const (
+	DefaultPredictHorizon = 10
+	MaxPredictHorizon     = 60
+)
+
+// Trajectory preview sent to thrusting players
+const (
+	PreviewDuration = 2 // Simulated seconds ahead
+	PreviewStride   = 5 // Steps between sent points
+)
+
+// PredictionMessage carries a player's predicted path
+type PredictionMessage struct {
+	Envelope
+	Points []Vector2 `json:"points"`
+}
+
+// ApproachPrediction is returned by the closest approach endpoint
+type ApproachPrediction struct {
+	A        string  `json:"a"`
+	B        string  `json:"b"`
+	Time     float64 `json:"time"`     // Seconds from now
+	Distance float64 `json:"distance"` // Separation at closest approach
+}
+
+// Integrate a copy of an entity forward, ignoring other bodies, and return its
+// position after each step. Prediction stops early if the entity hits the star.
+func predictTrajectory(entity Entity, steps int) []Vector2 {
+	points := make([]Vector2, 0, steps)
+	entity.external = Vector2{}
+	for i := 0; i < steps; i++ {
+		world.step(&entity)
+		points = append(points, entity.Position)
+		if hitsStar(entity.Position) || hitsPlanet(entity.Position) {
+			break
+		}
+	}
+	return points
+}
+
+// Build the trajectory preview for a client that wants one and is thrusting,
+// or nil. The caller must hold clientsMu.
+func previewFrame(client *Client) []byte {
+	entity := client.Entity
+	if !client.predict || client.Spectator || (entity.Thrust.X == 0 && entity.Thrust.Y == 0) {
+		return nil
+	}
+	path := predictTrajectory(entity, int(PreviewDuration/world.timeStep()))
+	points := make([]Vector2, 0, len(path)/PreviewStride+1)
+	for i := PreviewStride - 1; i < len(path); i += PreviewStride {
+		points = append(points, path[i])
+	}
+	data, err := json.Marshal(PredictionMessage{Envelope: envelope("prediction"), Points: points})
+	if err != nil {
+		logThrottled("JSON error:", err)
+		return nil
+	}
+	countMessages("out", "prediction", 1)
+	return data
+}
+
+// Find the time and distance of closest approach between two trajectories
+func closestApproach(a, b Entity, steps int) (float64, float64) {
+	d := displacement(a.Position, b.Position)
+	bestTime, bestDist := 0.0, math.Hypot(d.X, d.Y)
+
+	pathA := predictTrajectory(a, steps)
+	pathB := predictTrajectory(b, steps)
+	for i := 0; i < len(pathA) && i < len(pathB); i++ {
+		d := displacement(pathA[i], pathB[i])
+		if dist := math.Hypot(d.X, d.Y); dist < bestDist {
+			bestTime, bestDist = float64(i+1)*world.timeStep(), dist
+		}
+	}
+	return bestTime, bestDist
+}
+
+// Predict the closest approach of two entities within a horizon
+func approachHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	horizon := float64(DefaultPredictHorizon)
+	if s := query.Get("horizon"); s != "" {
+		h, err := strconv.ParseFloat(s, 64)
+		if err != nil || h <= 0 || h > MaxPredictHorizon {
+			http.Error(w, "invalid horizon", http.StatusBadRequest)
+			return
+		}
+		horizon = h
+	}
+
+	clientsMu.Lock()
+	a, okA := findEntity(query.Get("a"))
+	b, okB := findEntity(query.Get("b"))
+	if !okA || !okB {
+		clientsMu.Unlock()
+		http.Error(w, "entity not found", http.StatusNotFound)
+		return
+	}
+	t, dist := closestApproach(a, b, int(horizon/world.timeStep()))
+	clientsMu.Unlock()
+
+	writeJSON(w, ApproachPrediction{A: a.ID, B: b.ID, Time: t, Distance: dist})
+}
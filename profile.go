@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// Cookie carrying a returning player's secret profile token
+const (
+	PlayerCookie    = "space-web-player"
+	PlayerCookieAge = 365 * 24 * time.Hour
+)
+
+// Profile is what a returning player gets back on reconnect. Position and
+// velocity always reset.
+type Profile struct {
+	ID   string // Public entity ID the player comes back as
+	Name string
+
+	settings streamSettings // As of the last disconnect
+	expires  time.Time      // Set while disconnected, the profile is dropped after it
+}
+
+// streamSettings are the stream preferences a client set with messages,
+// restored on reconnect so dashboard viewers need not send them again. The
+// wire format is left out, each connection negotiates it afresh.
+type streamSettings struct {
+	snapshotEvery uint64
+	deltas        bool
+	batchEvents   bool
+	predict       bool
+}
+
+// Profiles by secret cookie token, guarded by clientsMu. The token is never
+// broadcast, only the profile's entity ID is, so knowing a player's ID is not
+// enough to take over their profile. Kept in memory only, and dropped once a
+// disconnected player has been gone for the resume grace.
+var profiles = make(map[string]Profile)
+
+// Issue a profile token cookie to browsers that do not have one
+func issuePlayerCookie(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(PlayerCookie); err == nil {
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     PlayerCookie,
+		Value:    hex.EncodeToString(b),
+		Path:     "/",
+		MaxAge:   int(PlayerCookieAge / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Profile token from the upgrade request's cookie, or "" if it has none.
+// The cookie value must look like one the server issued.
+func playerToken(r *http.Request) string {
+	cookie, err := r.Cookie(PlayerCookie)
+	if err != nil || len(cookie.Value) != 32 {
+		return ""
+	}
+	if _, err := hex.DecodeString(cookie.Value); err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// Pick the entity ID for a new connection and the profile to restore, and
+// report the token the connection saves its profile under. A token seen for
+// the first time starts a profile under the fresh ID. A second connection
+// with the same token gets a fresh ID and no profile, since entity IDs must
+// be unique. The caller must hold clientsMu.
+func resumeProfile(token, freshID string) (string, Profile, string) {
+	if token == "" {
+		return freshID, Profile{}, ""
+	}
+	profile, ok := profiles[token]
+	if !ok {
+		profiles[token] = Profile{ID: freshID}
+		return freshID, Profile{ID: freshID}, token
+	}
+	for client := range clients {
+		if client.Entity.ID == profile.ID {
+			return freshID, Profile{}, ""
+		}
+	}
+	profile.expires = time.Time{}
+	profiles[token] = profile
+	return profile.ID, profile, token
+}
+
+// Remember a returning player's details, the caller must hold clientsMu.
+// Connections without a profile are skipped.
+func saveProfile(client *Client) {
+	if profile, ok := profiles[client.token]; ok {
+		profile.Name = client.Entity.Name
+		profiles[client.token] = profile
+	}
+}
+
+// Start a disconnected client's resume grace, keeping its stream settings.
+// The caller must hold clientsMu.
+func expireProfile(client *Client) {
+	if profile, ok := profiles[client.token]; ok {
+		profile.settings = streamSettings{
+			snapshotEvery: client.snapshotEvery,
+			deltas:        client.deltas,
+			batchEvents:   client.batchEvents,
+			predict:       client.predict,
+		}
+		profile.expires = time.Now().Add(config.ResumeGrace)
+		profiles[client.token] = profile
+	}
+}
+
+// Apply the stream settings saved in a profile, the caller must hold
+// clientsMu
+func (c *Client) restoreSettings(settings streamSettings) {
+	c.snapshotEvery = settings.snapshotEvery
+	c.deltas = settings.deltas
+	c.batchEvents = settings.batchEvents
+	c.predict = settings.predict
+}
+
+// Drop profiles of players that did not return within the resume grace.
+// Their entities already left on disconnect.
+func profileSweeper() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		clientsMu.Lock()
+		for token, profile := range profiles {
+			if !profile.expires.IsZero() && now.After(profile.expires) {
+				delete(profiles, token)
+			}
+		}
+		clientsMu.Unlock()
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Projectile parameters
+const (
+	ProjectileMass  = 0.1                    // Light enough to barely disturb what it hits
+	ProjectileSpeed = 150                    // Launch speed relative to the shooter
+	ProjectileTTL   = 2 * time.Second        // Lifetime before it is removed
+	FireCooldown    = 250 * time.Millisecond // Minimum time between shots per player
+	MaxProjectiles  = 256                    // Projectiles alive across the server
+)
+
+// Projectiles are short-lived server-owned entities keyed by ID, guarded by
+// clientsMu
+var (
+	projectiles   = make(map[string]*Entity)
+	projectileSeq uint64
+)
+
+// Fire a projectile along the message's aim, or along the shooter's velocity
+// when no aim is given. It inherits the shooter's velocity.
+func handleFire(client *Client, msg ClientMessage) bool {
+	if !isFinite(msg.X) || !isFinite(msg.Y) {
+		log.Println("Message error: invalid aim")
+		return false
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	shooter := &client.Entity
+	if client.Spectator || !shooter.alive() {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(client.lastFire) < FireCooldown {
+		log.Println("Message error: fire cooldown")
+		return false
+	}
+	if len(projectiles) >= MaxProjectiles {
+		log.Println("Message error: projectile limit reached")
+		return false
+	}
+	dir := unit(Vector2{X: msg.X, Y: msg.Y})
+	if dir == (Vector2{}) {
+		dir = unit(shooter.Velocity)
+	}
+	if dir == (Vector2{}) {
+		log.Println("Message error: no direction to fire in")
+		return false
+	}
+	client.lastFire = now
+
+	projectileSeq++
+	radius := entityRadius(ProjectileMass)
+	gap := shooter.Radius + radius + 1
+	projectile := &Entity{
+		ID:        fmt.Sprintf("shot-%d", projectileSeq),
+		Position:  Vector2{X: shooter.Position.X + dir.X*gap, Y: shooter.Position.Y + dir.Y*gap},
+		Velocity:  Vector2{X: shooter.Velocity.X + dir.X*ProjectileSpeed, Y: shooter.Velocity.Y + dir.Y*ProjectileSpeed},
+		Mass:      ProjectileMass,
+		Radius:    radius,
+		State:     StateAlive,
+		Connected: true,
+		Owner:     shooter.ID,
+		ExpiresAt: now.Add(ProjectileTTL),
+	}
+	if config.Wrap {
+		projectile.Position = wrapPosition(projectile.Position)
+	}
+	projectiles[projectile.ID] = projectile
+	return true
+}
+
+// Report whether two entities share a shooter, so a player's projectiles
+// never hit the player or each other
+func friendly(a, b *Entity) bool {
+	return a.Owner == b.ID || b.Owner == a.ID || (a.Owner != "" && a.Owner == b.Owner)
+}
+
+// Use up any projectile in a collision
+func spendProjectiles(a, b *Entity) {
+	for _, e := range [2]*Entity{a, b} {
+		if e.Owner != "" {
+			e.State = StateDead
+		}
+	}
+}
+
+// Remove projectiles that expired, hit something or left play, the caller
+// must hold clientsMu
+func pruneProjectiles(now time.Time) {
+	for id, projectile := range projectiles {
+		if !projectile.alive() || now.After(projectile.ExpiresAt) {
+			delete(projectiles, id)
+			emitEvent(LeaveEvent{Envelope: envelope("leave"), ID: id})
+		}
+	}
+}
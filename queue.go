@@ -0,0 +1,84 @@
+package main
+
+import "time"
+
+// There is no Room type yet, so the player cap and waiting queue apply to the
+// single shared simulation.
+
+// PromotedMessage tells a queued spectator it now controls an entity
+type PromotedMessage struct {
+	Envelope
+	ID string `json:"id"`
+}
+
+// Spectators waiting for a player slot in arrival order, guarded by clientsMu
+var waiting []*Client
+
+// Count clients controlling an entity, the caller must hold clientsMu
+func playerCount() int {
+	n := 0
+	for client := range clients {
+		if !client.Spectator {
+			n++
+		}
+	}
+	return n
+}
+
+// Most players and spectators connected at once since startup, guarded by
+// clientsMu
+var peakPlayers, peakSpectators int
+
+// Raise the peaks to the current counts, the caller must hold clientsMu
+func recordPeaks() {
+	players := playerCount()
+	peakPlayers = max(peakPlayers, players)
+	peakSpectators = max(peakSpectators, len(clients)-players)
+}
+
+// Register a client, queueing it as a spectator when the simulation is full.
+// The caller must hold clientsMu.
+func admitClient(client *Client) {
+	if config.MaxPlayers > 0 && playerCount() >= config.MaxPlayers {
+		client.Spectator = true
+		client.Entity.State = StateSpectating
+		waiting = append(waiting, client)
+	}
+	clients[client] = struct{}{}
+	recordPeaks()
+}
+
+// Unregister a client and promote the next waiting spectator into a freed
+// slot, the caller must hold clientsMu. A player's entity leaves at once,
+// even when the profile is kept for a resume, which then rejoins under the
+// same ID.
+func removeClient(client *Client) {
+	delete(clients, client)
+	expireProfile(client)
+	if !client.Spectator {
+		emitEvent(LeaveEvent{Envelope: envelope("leave"), ID: client.Entity.ID})
+	}
+	notify("leave", map[string]string{"id": client.Entity.ID, "name": client.Entity.Name})
+	if client.Spectator {
+		for i, queued := range waiting {
+			if queued == client {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	if len(waiting) == 0 {
+		return
+	}
+
+	next := waiting[0]
+	waiting = waiting[1:]
+	next.Spectator = false
+	next.lastInput = time.Now()
+	// The name, meta and shape set while queued carry over, only the
+	// kinematics start fresh
+	world.respawn(&next.Entity)
+	next.send(PromotedMessage{Envelope: envelope("promoted"), ID: next.Entity.ID})
+	recordPeaks()
+}
@@ -0,0 +1,34 @@
+package main
+
+import "math"
+
+// CorrectionMessage answers a reported position that strayed beyond the
+// tolerance with the authoritative state, for the client to snap back to
+type CorrectionMessage struct {
+	Envelope
+	Position Vector2 `json:"position"`
+	Velocity Vector2 `json:"velocity"`
+	Error    float64 `json:"error"` // Distance between the reported and authoritative positions
+}
+
+// Check a client-predicted position against the server's. The server stays
+// authoritative and never applies a reported position, so a cheating client
+// gains nothing, an implausible one is logged and corrected. The caller must
+// hold clientsMu.
+func checkReportedPosition(client *Client, reported Vector2) {
+	if config.PositionTolerance <= 0 || client.Spectator || !client.Entity.alive() {
+		return
+	}
+	if !isFinite(reported.X) || !isFinite(reported.Y) {
+		logThrottled("Message error:", "invalid reported position")
+		return
+	}
+	d := displacement(client.Entity.Position, reported)
+	off := math.Hypot(d.X, d.Y)
+	if off <= config.PositionTolerance {
+		return
+	}
+	logThrottled("Message error:", "reported position off by", off)
+	client.send(CorrectionMessage{Envelope: envelope("correction"), Position: client.Entity.Position,
+		Velocity: client.Entity.Velocity, Error: off})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// Resonance detection parameters
+const (
+	ResonanceInterval  = TickRate // Ticks between checks
+	ResonanceWindow    = 5        // Consecutive matching checks before reporting
+	ResonanceTolerance = 0.02     // Allowed relative error in the period ratio
+)
+
+// Integer period ratios checked, larger period first
+var resonanceRatios = [][2]int{{2, 1}, {3, 2}, {3, 1}, {4, 3}, {5, 3}, {5, 2}}
+
+// ResonanceEvent is sent when two orbits settle into an integer period ratio
+type ResonanceEvent struct {
+	Envelope
+	A     string `json:"a"` // Entity with the longer period
+	B     string `json:"b"`
+	Ratio string `json:"ratio"`
+}
+
+// Per-pair resonance tracking, guarded by clientsMu
+type resonanceState struct {
+	ratio    string
+	streak   int
+	reported bool
+	seen     uint64 // Tick of the last check the pair was found in
+}
+
+var resonances = make(map[[2]string]*resonanceState)
+
+// Match a period ratio against the checked integer ratios
+func matchResonance(long, short float64) string {
+	for _, r := range resonanceRatios {
+		want := float64(r[0]) / float64(r[1])
+		if math.Abs(long/short-want)/want <= ResonanceTolerance {
+			return fmt.Sprintf("%d:%d", r[0], r[1])
+		}
+	}
+	return ""
+}
+
+// Check bound pairs for period resonances, reporting ones that hold for the
+// whole window. The caller must hold clientsMu.
+func detectResonances(all []*Entity) {
+	if !config.Resonance || world.tick%ResonanceInterval != 0 {
+		return
+	}
+
+	periods := make([]float64, len(all))
+	for i, entity := range all {
+		periods[i] = orbitalElements(*entity).Period
+	}
+	for i := range all {
+		for j := i + 1; j < len(all); j++ {
+			a, b := all[i], all[j]
+			pa, pb := periods[i], periods[j]
+			if math.IsInf(pa, 1) || math.IsInf(pb, 1) {
+				continue
+			}
+			if pa < pb {
+				a, b, pa, pb = b, a, pb, pa
+			}
+			ratio := matchResonance(pa, pb)
+			key := [2]string{a.ID, b.ID}
+			state := resonances[key]
+			if ratio == "" {
+				delete(resonances, key)
+				continue
+			}
+			if state == nil || state.ratio != ratio {
+				state = &resonanceState{ratio: ratio}
+				resonances[key] = state
+			}
+			state.streak = min(state.streak+1, ResonanceWindow) // Only compared against the window
+			state.seen = world.tick
+			if state.streak >= ResonanceWindow && !state.reported {
+				state.reported = true
+				log.Printf("Resonance: %s and %s in %s", a.ID, b.ID, ratio)
+				emitEvent(ResonanceEvent{Envelope: envelope("resonance"), A: a.ID, B: b.ID, Ratio: ratio})
+			}
+		}
+	}
+
+	// Forget pairs whose entities are gone
+	for key, state := range resonances {
+		if state.seen != world.tick {
+			delete(resonances, key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// How long before the runtime cap clients are warned
+const RuntimeNoticeLead = 10 * time.Second
+
+// RuntimeNoticeMessage warns every client that the runtime cap is about to
+// reset the simulation or stop the server
+type RuntimeNoticeMessage struct {
+	Envelope
+	Action string  `json:"action"` // "reset" or "exit"
+	In     float64 `json:"in"`     // Seconds until it happens
+}
+
+// Reset or stop the server every time the runtime cap elapses, warning
+// clients shortly before, for unattended looping demos
+func enforceRuntime(server *http.Server, stopped chan<- struct{}) {
+	lead := min(RuntimeNoticeLead, config.MaxRuntime)
+	for {
+		time.Sleep(config.MaxRuntime - lead)
+		clientsMu.Lock()
+		broadcastNow(RuntimeNoticeMessage{Envelope: envelope("runtime_notice"), Action: config.RuntimeAction, In: lead.Seconds()})
+		clientsMu.Unlock()
+		time.Sleep(lead)
+
+		if config.RuntimeAction == "exit" {
+			log.Println("Runtime cap reached, shutting down")
+			clientsMu.Lock()
+			for client := range clients {
+				client.requestClose(websocket.CloseGoingAway, "server shutting down")
+			}
+			clientsMu.Unlock()
+			// Each writer sends what it has queued and then the close
+			// frame, both under the write timeout
+			ctx, cancel := context.WithTimeout(context.Background(), 2*config.WriteTimeout)
+			server.Shutdown(ctx)
+			drained := make(chan struct{})
+			go func() {
+				writers.Wait()
+				close(drained)
+			}()
+			select {
+			case <-drained:
+			case <-ctx.Done():
+				log.Println("Shutdown timed out before every client was closed")
+			}
+			cancel()
+			close(stopped)
+			return
+		}
+		log.Println("Runtime cap reached, resetting the simulation")
+		if err := resetSimulation(); err != nil {
+			log.Println("Scenario error:", err)
+		}
+	}
+}
+
+// Send a message to every client at once rather than with the next
+// broadcast, the caller must hold clientsMu
+func broadcastNow(msg Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logThrottled("JSON error:", err)
+		return
+	}
+	for client := range clients {
+		client.enqueue(nil, [][]byte{data})
+	}
+	countMessages("out", msg.messageType(), len(clients))
+}
+
+// Remove every bot, projectile and gravity well, respawn every player on a fresh orbit,
+// clear the scoreboard and load the startup scenario again. A scenario that no
+// longer loads leaves the simulation empty and is reported as an error.
+func resetSimulation() error {
+	clientsMu.Lock()
+	now := time.Now()
+	for _, entity := range bodies() {
+		detach(entity)
+	}
+	for id := range bots {
+		emitEvent(LeaveEvent{Envelope: envelope("leave"), ID: id})
+	}
+	for id := range projectiles {
+		emitEvent(LeaveEvent{Envelope: envelope("leave"), ID: id})
+	}
+	bots = make(map[string]*Entity)
+	projectiles = make(map[string]*Entity)
+	godTarget = nil
+	for _, well := range world.wells {
+		emitEvent(GravityWellEndEvent{Envelope: envelope("gravity_well_end"), ID: well.id})
+	}
+	world.wells = nil
+	for client := range clients {
+		if !client.Spectator {
+			world.respawn(&client.Entity)
+		}
+	}
+	resetScores(now)
+	simStart = now
+	clientsMu.Unlock()
+
+	return loadScenario()
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Version of the scenario file format
+const ScenarioVersion = 1
+
+// Largest bulk update body accepted, enough for a full world of bots
+const MaxBulkUpdateBytes = 4 << 20
+
+// Scenario is a saved set of entities that can be loaded at startup
+type Scenario struct {
+	Version  int              `json:"version"`
+	Entities []ScenarioEntity `json:"entities"`
+}
+
+// ScenarioEntity is the saved state of one entity
+type ScenarioEntity struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name,omitempty"`
+	Position Vector2 `json:"position"`
+	Velocity Vector2 `json:"velocity"`
+	Mass     float64 `json:"mass"`
+	Static   bool    `json:"static,omitempty"`
+	Shape    Shape   `json:"shape,omitempty"`
+	Length   float64 `json:"length,omitempty"`
+	Dead     bool    `json:"dead,omitempty"` // Saved while out of play, loads on a fresh orbit
+}
+
+// Capture every simulated entity as a scenario, the caller must hold clientsMu
+func captureScenario() Scenario {
+	all := bodies()
+	scenario := Scenario{Version: ScenarioVersion, Entities: make([]ScenarioEntity, 0, len(all))}
+	for _, entity := range all {
+		scenario.Entities = append(scenario.Entities, ScenarioEntity{
+			ID:       entity.ID,
+			Name:     entity.Name,
+			Position: entity.Position,
+			Velocity: entity.Velocity,
+			Mass:     entity.Mass,
+			Static:   entity.Static,
+			Shape:    entity.Shape,
+			Length:   entity.Length,
+			Dead:     !entity.alive(),
+		})
+	}
+	return scenario
+}
+
+// Serve the current entities as a scenario file
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMu.Lock()
+	scenario := captureScenario()
+	clientsMu.Unlock()
+
+	w.Header().Set("Content-Disposition", `attachment; filename="scenario.json"`)
+	writeJSON(w, scenario)
+}
+
+// Read and validate a scenario file
+func readScenario(path string) (Scenario, error) {
+	var scenario Scenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario, err
+	}
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return scenario, err
+	}
+	if scenario.Version != ScenarioVersion {
+		return scenario, fmt.Errorf("unsupported scenario version %d", scenario.Version)
+	}
+	return scenario, validateEntities(scenario.Entities)
+}
+
+// Check saved entities have unique IDs and finite, positive-mass state
+func validateEntities(entities []ScenarioEntity) error {
+	seen := make(map[string]bool, len(entities))
+	for _, e := range entities {
+		if e.ID == "" || seen[e.ID] {
+			return fmt.Errorf("missing or duplicate entity id %q", e.ID)
+		}
+		seen[e.ID] = true
+		if e.Mass <= 0 || !isFinite(e.Mass) || !isFinite(e.Position.X) || !isFinite(e.Position.Y) ||
+			!isFinite(e.Velocity.X) || !isFinite(e.Velocity.Y) {
+			return fmt.Errorf("entity %q has invalid state", e.ID)
+		}
+		if err := validShape(e.Shape, e.Length); err != nil {
+			return fmt.Errorf("entity %q: %v", e.ID, err)
+		}
+	}
+	return nil
+}
+
+// Lagrange demo parameters
+const (
+	LagrangePlanetMass   = 100 // Small enough to keep its radius clear of the trojans
+	LagrangePlanetRadius = 200 // Orbital radius of the planet
+)
+
+// Build a planet on a circular orbit with a small body at each of its L4 and
+// L5 points, 60 degrees ahead of and behind it on the same orbit and moving
+// with it. There is a single fixed star rather than a binary, so this is the
+// restricted three-body case with the star at the barycenter, which the small
+// mass ratio makes a close approximation. The trojans only feel the planet
+// with -nbody. The orbit is circular under the current gravity multiplier, the
+// caller must hold clientsMu.
+func lagrangeScenario() Scenario {
+	if config.NoStar {
+		log.Fatal("Config error: the lagrange scenario needs the star")
+	}
+	if config.GravityExponent != 2 {
+		log.Fatal("Config error: the lagrange scenario needs inverse-square gravity, the L4 and L5 points do not hold under -gravity-exponent")
+	}
+	if !config.NBody {
+		log.Println("Scenario warning: lagrange needs -nbody for the planet to hold its trojans")
+	}
+	star := starPosition()
+	speed := calculateOrbitalVelocity(starMass()*world.gravityMultiplier, LagrangePlanetRadius)
+	body := func(id string, mass, angle float64) ScenarioEntity {
+		return ScenarioEntity{
+			ID:       id,
+			Position: Vector2{X: star.X + LagrangePlanetRadius*math.Cos(angle), Y: star.Y + LagrangePlanetRadius*math.Sin(angle)},
+			Velocity: Vector2{X: -speed * math.Sin(angle), Y: speed * math.Cos(angle)},
+			Mass:     mass,
+		}
+	}
+	return Scenario{Version: ScenarioVersion, Entities: []ScenarioEntity{
+		body("planet", LagrangePlanetMass, 0),
+		body("trojan-l4", 1, math.Pi/3),
+		body("trojan-l5", 1, -math.Pi/3),
+	}}
+}
+
+// Scenario loaded at startup and again on every reset, as file:path or
+// lagrange, or "" for none. Set once while parsing flags, so config.Scenario
+// and config.Load keep the values the operator gave.
+var scenarioSource string
+
+// Load the configured scenario as server-owned bots. Player entities in the
+// file come back as bots, since their connections are gone.
+func loadScenario() error {
+	if scenarioSource == "" {
+		return nil
+	}
+	var scenario Scenario
+	if scenarioSource == "lagrange" {
+		clientsMu.Lock()
+		scenario = lagrangeScenario()
+		clientsMu.Unlock()
+	} else {
+		path, _ := strings.CutPrefix(scenarioSource, "file:")
+		var err error
+		if scenario, err = readScenario(path); err != nil {
+			return err
+		}
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	spawned := make([]Entity, 0, len(scenario.Entities))
+	for _, e := range scenario.Entities {
+		bot := world.newEntity(e.ID, e.Mass)
+		bot.Name = e.Name
+		bot.Static = e.Static
+		bot.Shape, bot.Length = e.Shape, e.Length
+		if !e.Dead {
+			bot.Position = e.Position
+			bot.Velocity = e.Velocity
+		}
+		spawned = append(spawned, bot)
+	}
+
+	loaded := insertBots(spawned)
+	if len(loaded) < len(spawned) {
+		log.Println("Scenario loaded", len(loaded), "of", len(spawned), "entities, bot limit reached")
+	}
+	return nil
+}
+
+// Create or replace entities by ID in one step. Every entity is validated and
+// the bot cap checked before any is applied, so a rejected request changes
+// nothing. Unknown IDs become bots, dead ones on a fresh orbit, and dead
+// entities given a live state come back into play.
+func bulkUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var entities []ScenarioEntity
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBulkUpdateBytes)
+	if err := json.NewDecoder(r.Body).Decode(&entities); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("body exceeds %d bytes", MaxBulkUpdateBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "body must be a JSON array of entities", http.StatusBadRequest)
+		return
+	}
+	if err := validateEntities(entities); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	created := 0
+	for _, e := range entities {
+		if projectiles[e.ID] != nil {
+			http.Error(w, fmt.Sprintf("entity %q is a projectile", e.ID), http.StatusConflict)
+			return
+		}
+		if lookupEntity(e.ID) == nil {
+			created++
+		}
+	}
+	if config.MaxBots > 0 && created > config.MaxBots-len(bots) {
+		http.Error(w, "bot limit reached", http.StatusConflict)
+		return
+	}
+
+	result := make([]Entity, 0, len(entities))
+	var spawned []Entity
+	for _, e := range entities {
+		entity := lookupEntity(e.ID)
+		existing := entity != nil
+		if !existing {
+			bot := world.newEntity(e.ID, e.Mass)
+			entity = &bot
+		}
+		entity.Name = e.Name
+		entity.Mass = e.Mass
+		entity.Radius = entityRadius(e.Mass)
+		entity.burned = 0 // The new mass replaces any propellant to restore
+		entity.Static = e.Static
+		entity.Shape, entity.Length = e.Shape, e.Length
+		switch {
+		case !e.Dead:
+			entity.Position = e.Position
+			entity.Velocity = e.Velocity
+			entity.phaseSet = false // Teleports do not count as orbital motion
+			if !entity.alive() {
+				entity.State = StateAlive
+				entity.Health = spawnHealth()
+			}
+		case existing && entity.alive():
+			world.kill(entity)
+		}
+		if !existing {
+			spawned = append(spawned, *entity)
+		}
+		result = append(result, *entity)
+	}
+	insertBots(spawned)
+	writeJSON(w, result)
+}
+
+// Periodically write the current state to the autosave file in the export
+// format, replacing it atomically so a crash mid-write keeps the last save
+func autosave() {
+	ticker := time.NewTicker(config.AutosaveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		clientsMu.Lock()
+		scenario := captureScenario()
+		clientsMu.Unlock()
+		if err := writeScenario(config.Autosave, scenario); err != nil {
+			log.Println("Autosave error:", err)
+		}
+	}
+}
+
+// Write a scenario file through a temporary file and rename
+func writeScenario(path string, scenario Scenario) error {
+	data, err := json.Marshal(scenario)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
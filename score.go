@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Round and scoreboard state, guarded by clientsMu. Scores are the current
+// round's points keyed by entity ID, one per entity destroyed plus any
+// gravity assist points.
+var (
+	scores     = make(map[string]int)
+	round      = 1
+	roundStart time.Time
+)
+
+// Ranking is one scoreboard row
+type Ranking struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Score int    `json:"score"`
+}
+
+// RoundEndEvent is sent when a round's time runs out, with its final
+// rankings. Scores then reset and the next round begins.
+type RoundEndEvent struct {
+	Envelope
+	Round    int       `json:"round"`
+	Rankings []Ranking `json:"rankings"`
+}
+
+// Credit a destroy to the responsible player, the caller must hold clientsMu
+func scoreDestroy(victim, by string) {
+	if by != "" && by != victim {
+		scores[by]++
+	}
+}
+
+// Points for a gravity assist pass at the ring's outer edge at circular speed
+const AssistPoints = 10
+
+// AssistEvent is sent when an entity completes a scoring pass of the gravity
+// assist ring
+type AssistEvent struct {
+	Envelope
+	ID       string  `json:"id"`
+	Points   int     `json:"points"`
+	Speed    float64 `json:"speed"`    // Fastest speed during the pass
+	Distance float64 `json:"distance"` // Closest distance to the star during the pass
+}
+
+// Track passes through the gravity assist ring and score each one made on a
+// bound orbit, scaled up by how much faster than circular at the outer edge
+// and how much closer than that edge it went. Leaving the ring, or leaving
+// it unbound or out of play, ends the pass. The caller must hold clientsMu.
+func checkAssist(entity *Entity) {
+	if config.AssistOuter == 0 {
+		return
+	}
+	d := displacement(starPosition(), entity.Position)
+	r := math.Hypot(d.X, d.Y)
+	inside := r >= config.AssistInner && r <= config.AssistOuter
+	bound := entity.alive() && specificEnergy(*entity) < 0
+	if inside && bound {
+		speed := math.Hypot(entity.Velocity.X, entity.Velocity.Y)
+		if !entity.inRing {
+			entity.inRing, entity.ringSpeed, entity.ringDepth = true, speed, r
+		}
+		entity.ringSpeed = math.Max(entity.ringSpeed, speed)
+		entity.ringDepth = math.Min(entity.ringDepth, r)
+		return
+	}
+	if !entity.inRing {
+		return
+	}
+	entity.inRing = false
+	if !bound {
+		return
+	}
+	reference := calculateOrbitalVelocity(starMass(), config.AssistOuter)
+	closeness := config.AssistOuter / math.Max(entity.ringDepth, config.StarRadius)
+	points := int(math.Round(AssistPoints * entity.ringSpeed / reference * closeness))
+	scores[entity.ID] += points
+	emitEvent(AssistEvent{Envelope: envelope("assist"), ID: entity.ID, Points: points,
+		Speed: entity.ringSpeed, Distance: entity.ringDepth})
+}
+
+// Scores ranked highest first, ties broken by ID. The caller must hold
+// clientsMu.
+func rankings() []Ranking {
+	ranked := make([]Ranking, 0, len(scores))
+	for id, score := range scores {
+		row := Ranking{ID: id, Score: score}
+		if entity := lookupEntity(id); entity != nil {
+			row.Name = entity.Name
+		}
+		ranked = append(ranked, row)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+	return ranked
+}
+
+// End the round once its duration has passed, the caller must hold clientsMu
+func advanceRound(now time.Time) {
+	if config.RoundDuration <= 0 {
+		return
+	}
+	if roundStart.IsZero() {
+		roundStart = now
+	}
+	if now.Sub(roundStart) < config.RoundDuration {
+		return
+	}
+	emitEvent(RoundEndEvent{Envelope: envelope("round_end"), Round: round, Rankings: rankings()})
+	notify("round_end", map[string]int{"round": round})
+	resetScores(now)
+}
+
+// Clear the scoreboard and start a new round, the caller must hold clientsMu
+func resetScores(now time.Time) {
+	scores = make(map[string]int)
+	round++
+	roundStart = now
+}
+
+// Serve the current round's scoreboard, the top rankings up to the response cap
+func scoresHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMu.Lock()
+	head := struct {
+		Round     int     `json:"round"`
+		Remaining float64 `json:"remaining,omitempty"` // Seconds left in the round, absent without rounds
+	}{Round: round}
+	if config.RoundDuration > 0 && !roundStart.IsZero() {
+		head.Remaining = max(config.RoundDuration-time.Since(roundStart), 0).Seconds()
+	}
+	ranked := rankings()
+	clientsMu.Unlock()
+	writeList(w, head, "rankings", ranked[:min(len(ranked), config.MaxResponseEntities)])
+}
+
+// Reset the scoreboard and restart the round timer without tallying
+func resetScoresHandler(w http.ResponseWriter, r *http.Request) {
+	clientsMu.Lock()
+	resetScores(time.Now())
+	clientsMu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
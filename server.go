@@ -7,7 +7,9 @@ import (
 	"math"
 	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -20,14 +22,82 @@ const (
 	MinDistance = 10      // Minimum distance from star for initial position
 	MaxDistance = 100     // Maximum distance for initial position
 	TimeStep    = 0.016   // Simulation step (approx 60 FPS)
+	TickRate    = 60      // Physics and broadcast ticks per second
+	WorldWidth  = 800     // World width, matches the test page canvas
+	WorldHeight = 600     // World height, matches the test page canvas
 )
 
 // Entity represents a client's state
 type Entity struct {
 	ID        string
+	Name      string `json:",omitempty"`
 	Position  Vector2
 	Velocity  Vector2
-	Connected bool
+	Mass      float64
+	Radius    float64         // Collision and render radius, derived from mass
+	Thrust    Vector2         // Acceleration from the player's engine
+	Meta      json.RawMessage `json:",omitempty"` // Client-supplied metadata, not interpreted
+	State     EntityState
+	Connected bool      // Deprecated: always true, use State
+	Fuel      float64   `json:",omitempty"` // Delta-v left for thrust and impulses, absent when empty or disabled
+	Health    float64   `json:",omitempty"` // Absent when collision damage is disabled
+	Static    bool      `json:",omitempty"` // Skips integration, for inspection
+	ExpiresAt time.Time `json:"-"`          // Bots and projectiles only, zero means never
+	Owner     string    `json:",omitempty"` // Projectiles only, the entity that fired it
+	Group     string    `json:",omitempty"` // Rigid group the entity is linked into
+	Phase     float64   `json:",omitempty"` // Unwrapped angle swept around the star in radians, counterclockwise positive
+	Heading   *float64  `json:",omitempty"` // Direction of travel in radians from +X, broadcasts with -heading only, absent when still
+
+	// Facing of a ship flown with input messages, in radians from +X
+	Orientation float64 `json:",omitempty"`
+
+	// Immune to damage after a respawn, with the seconds of protection left
+	Invulnerable    bool    `json:",omitempty"`
+	InvulnerableFor float64 `json:",omitempty"`
+
+	// Collision shape and a capsule's segment length, see Shape
+	Shape  Shape   `json:",omitempty"`
+	Length float64 `json:",omitempty"`
+
+	// Acceleration from other entities, refreshed each tick
+	external Vector2
+
+	// Mass expelled as exhaust since the last respawn
+	burned float64
+
+	// Thrust acceleration the last step applied, after the gravity ratio cap
+	// and fuel
+	appliedThrust Vector2
+
+	// Polar angle around the star at the last phase update, valid once
+	// phaseSet is true
+	angle    float64
+	phaseSet bool
+
+	// Tick a respawning entity comes back into play, and the tick its
+	// spawn protection ends
+	respawnTick   uint64
+	protectedTick uint64
+
+	// Refuel zones the entity is inside, one bit per zone
+	zones uint64
+
+	// Close star pass in progress for slingshot detection, with the specific
+	// energy in the fixed potential on entering
+	approaching    bool
+	approachEnergy float64
+
+	// Gravity assist ring pass in progress, with its fastest speed and
+	// closest distance so far
+	inRing    bool
+	ringSpeed float64
+	ringDepth float64
+
+	// Sleep state of a quiet bot, see updateSleep
+	sleeping   bool
+	quietTicks int
+	skipped    int // Ticks not yet integrated while asleep
+	lastSpeed  float64
 }
 
 // Vector2 for 2D coordinates
@@ -35,161 +105,789 @@ type Vector2 struct {
 	X, Y float64
 }
 
+// Star describes the central star
+type Star struct {
+	Position Vector2 `json:"position"`
+	Mass     float64 `json:"mass"`
+	Radius   float64 `json:"radius"`
+}
+
 // ClientUpdate is sent to clients
 type ClientUpdate struct {
+	Envelope
+	Star     Star     `json:"star"`
+	Gravity  float64  `json:"gravity"` // Gravity difficulty multiplier
+	Rate     int      `json:"rate"`    // Snapshots per second currently sent
+	Scale    float64  `json:"scale"`   // Simulated seconds per real second
+	Paused   bool     `json:"paused"`  // Physics is paused, entities hold still
 	Entities []Entity `json:"entities"`
+
+	// Events of the tick, only for clients that asked for batching
+	Events []json.RawMessage `json:"events,omitempty"`
+
+	// More pages of the same snapshot follow, when split across frames
+	More bool `json:"more"`
+}
+
+// Marshal a snapshot into frames of at most the configured entity count, with
+// every page but the last marked as having more. Events ride on the last page.
+func marshalSnapshot(update ClientUpdate) ([][]byte, error) {
+	all := update.Entities
+	size := config.MaxFrameEntities
+	if size <= 0 || len(all) <= size {
+		data, err := json.Marshal(update)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{data}, nil
+	}
+
+	var pages [][]byte
+	events := update.Events
+	for start := 0; start < len(all); start += size {
+		end := min(start+size, len(all))
+		update.Entities = all[start:end]
+		update.More = end < len(all)
+		update.Events = nil
+		if !update.More {
+			update.Events = events
+		}
+		data, err := json.Marshal(update)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, data)
+	}
+	return pages, nil
+}
+
+// Total bytes across frames
+func frameBytes(frames [][]byte) int {
+	n := 0
+	for _, frame := range frames {
+		n += len(frame)
+	}
+	return n
+}
+
+// Marshal a snapshot carrying the tick's events, or nil when no client batches
+// or there is nothing to batch
+func batchedSnapshot(update ClientUpdate, data [][]byte, eventFrames [][]byte, batching bool) [][]byte {
+	if !batching || data == nil || len(eventFrames) == 0 {
+		return nil
+	}
+	update.Events = make([]json.RawMessage, len(eventFrames))
+	for i, frame := range eventFrames {
+		update.Events[i] = frame
+	}
+	batched, err := marshalSnapshot(update)
+	if err != nil {
+		logThrottled("JSON error:", err)
+		return nil
+	}
+	return batched
 }
 
+// OnTick, if set, is called after each physics tick with a copy of all
+// entities. It runs on the physics goroutine outside the state lock, so a slow
+// hook delays the next tick but never blocks connection handling.
+var OnTick func(entities []Entity)
+
 // Global state
 var (
-	clients   = make(map[*websocket.Conn]Entity)
+	clients   = make(map[*Client]struct{})
 	clientsMu sync.Mutex
-	upgrader  = websocket.Upgrader{
+	events    []Message // Events queued for the next broadcast, guarded by clientsMu
+
+	// Running connection writers, waited on before the server exits
+	writers sync.WaitGroup
+
+	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		Subprotocols:    []string{BinarySubprotocol},
+		CheckOrigin:     allowedOrigin,
 	}
 )
 
+// Accept any origin unless an allow list is configured
+func allowedOrigin(r *http.Request) bool {
+	if config.Origins == "" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	for _, allowed := range strings.Split(config.Origins, ",") {
+		if strings.TrimSpace(allowed) == origin {
+			return true
+		}
+	}
+	return false
+}
+
 // Generate random position
 func randomPosition() Vector2 {
 	// Polar coordinates for even distribution
-	// theta := rand.Float64() * 2 * math.Pi
+	theta := rand.Float64() * 2 * math.Pi
 	r := MinDistance + rand.Float64()*(MaxDistance-MinDistance)
-	// x := r * math.Cos(theta)
-	// y := r * math.Sin(theta)
-
-	return Vector2{X: r, Y: 0}
+	return Vector2{X: r * math.Cos(theta), Y: r * math.Sin(theta)}
 }
 
 func calculateOrbitalVelocity(mass float64, radius float64) float64 {
-	return math.Sqrt((G * mass) / radius)
+	return math.Sqrt(G * mass * radius / inversePower(radius))
+}
+
+// Distance raised to the gravity exponent, with inverse-square kept exact
+func inversePower(r float64) float64 {
+	if config.GravityExponent == 2 {
+		return r * r
+	}
+	return math.Pow(r, config.GravityExponent)
+}
+
+// Velocity for an orbit of the configured eccentricity passing through pos,
+// relative to the star, at a random true anomaly. From the orbit equation r = p / (1 + e cos nu), the
+// radial and tangential speeds are sqrt(mu/p) e sin nu and sqrt(mu/p)(1 + e cos nu),
+// which satisfy vis-viva. The star's pull includes the world's current gravity
+// multiplier, the caller must hold w.mu.
+func (w *World) spawnVelocity(pos Vector2) Vector2 {
+	r := math.Hypot(pos.X, pos.Y)
+	e := config.Eccentricity
+	mass := starMass() * w.gravityMultiplier
+	if e == 0 || config.GravityExponent != 2 {
+		v := calculateOrbitalVelocity(mass, r)
+		return Vector2{X: -pos.Y / r * v, Y: pos.X / r * v}
+	}
+
+	nu := rand.Float64() * 2 * math.Pi
+	p := r * (1 + e*math.Cos(nu))
+	k := math.Sqrt(G * mass / p)
+	vr, vt := k*e*math.Sin(nu), k*(1+e*math.Cos(nu))
+	radial := Vector2{X: pos.X / r, Y: pos.Y / r}
+	return Vector2{
+		X: vr*radial.X - vt*radial.Y,
+		Y: vr*radial.Y + vt*radial.X,
+	}
+}
+
+// Pick a spawn offset from the star that does not overlap another entity
+// or one of the placed entities not yet in the simulation. Random picks are
+// tried up to the configured attempts, then the first free ring slot, and
+// the last random pick is kept if the spawn area is full. The caller must
+// hold w.mu.
+func (w *World) spawnOffset(id string, radius float64, placed []Entity) Vector2 {
+	star := starPosition()
+	all := w.bodies()
+	free := func(offset Vector2) bool {
+		pos := Vector2{X: star.X + offset.X, Y: star.Y + offset.Y}
+		clear := func(other *Entity) bool {
+			d := displacement(pos, other.Position)
+			return other.ID == id || math.Hypot(d.X, d.Y) >= (radius+other.Radius)*config.CollisionRadiusScale
+		}
+		for _, other := range all {
+			if !clear(other) {
+				return false
+			}
+		}
+		for i := range placed {
+			if !clear(&placed[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	offset := randomPosition()
+	for attempt := 1; attempt <= config.SpawnAttempts; attempt++ {
+		if free(offset) {
+			return offset
+		}
+		if attempt < config.SpawnAttempts {
+			offset = randomPosition()
+		}
+	}
+	if slot, ok := freeSlot(radius, free); ok {
+		return slot
+	}
+	return offset
+}
+
+// Find the first free offset scanning rings outward from MinDistance to
+// MaxDistance, each ring and each slot along it one spawn diameter apart
+func freeSlot(radius float64, free func(Vector2) bool) (Vector2, bool) {
+	step := math.Max(2*radius*config.CollisionRadiusScale, 1)
+	for r := float64(MinDistance); r <= MaxDistance; r += step {
+		slots := max(int(2*math.Pi*r/step), 1)
+		for i := 0; i < slots; i++ {
+			theta := 2 * math.Pi * float64(i) / float64(slots)
+			if offset := (Vector2{X: r * math.Cos(theta), Y: r * math.Sin(theta)}); free(offset) {
+				return offset, true
+			}
+		}
+	}
+	return Vector2{}, false
+}
+
+// Create an entity on an orbit of the configured eccentricity, clear of the
+// world's entities, the caller must hold w.mu
+func (w *World) newEntity(id string, mass float64) Entity {
+	return w.placeEntity(id, mass, nil)
+}
+
+// Create an entity like newEntity that also keeps clear of entities placed
+// earlier in the same batch, the caller must hold w.mu
+func (w *World) placeEntity(id string, mass float64, placed []Entity) Entity {
+	offset := w.spawnOffset(id, entityRadius(mass), placed)
+	star := starPosition()
+	entity := Entity{
+		ID:        id,
+		Position:  Vector2{X: star.X + offset.X, Y: star.Y + offset.Y},
+		Velocity:  Vector2{}, // Start with zero velocity
+		Mass:      mass,
+		Radius:    entityRadius(mass),
+		Fuel:      config.FuelCapacity,
+		Health:    spawnHealth(),
+		State:     StateAlive,
+		Connected: true,
+	}
+	entity.Velocity = w.spawnVelocity(offset)
+	return entity
+}
+
+// Advance an entity by one time step, the caller must hold w.mu. The steps
+// here and in stepFor are reported by entitySteps, keep the two in step.
+func (w *World) integrate(entity *Entity) {
+	if entity.State == StateRespawning && w.tick >= entity.respawnTick {
+		w.respawn(entity)
+	}
+	w.updateProtection(entity)
+	if !entity.alive() || entity.Static {
+		return
+	}
+	// A sleeping entity covers the ticks it skipped in one longer step
+	if entity.sleeping && entity.skipped+1 < config.SleepStride {
+		entity.skipped++
+		return
+	}
+	before := entity.Position
+	w.stepFor(entity, w.timeStep()*float64(entity.skipped+1))
+	if entity.skipped == 0 {
+		w.checkTunneling(entity, before)
+	}
+	entity.skipped = 0
+	if outOfBounds(entity) {
+		log.Printf("Physics error: entity %s out of bounds, respawning: %+v", entity.ID, *entity)
+		w.kill(entity)
+		return
+	}
+	trackPhase(entity)
+	// Entities that hit the star or a planet die and respawn on a fresh orbit
+	if hitsStar(entity.Position) || hitsPlanet(entity.Position) {
+		w.kill(entity)
+	}
+}
+
+// Apply one time step of motion under gravity, with no side effects
+func (w *World) step(entity *Entity) {
+	w.stepFor(entity, w.timeStep())
+}
+
+// Apply dt seconds of motion under gravity, with no side effects. Velocity
+// is updated before position, semi-implicit Euler, which keeps orbit energy
+// bounded where explicit Euler would let it grow.
+func (w *World) stepFor(entity *Entity, dt float64) {
+	// Calculate acceleration due to gravity
+	accel := w.gravitationalAccel(entity.Position)
+	thrust := thrustAccel(entity)
+	if config.ThrustGravityRatio > 0 {
+		thrust = clampMagnitude(thrust, config.ThrustGravityRatio*math.Hypot(accel.X, accel.Y))
+	}
+	regenFuel(entity, dt)
+	thrust = burnFuel(entity, thrust, dt)
+	entity.appliedThrust = thrust
+	expelMass(entity, thrust, dt)
+	accel.X += thrust.X + entity.external.X
+	accel.Y += thrust.Y + entity.external.Y
+	drag := atmosphericDrag(entity)
+	accel.X += drag.X
+	accel.Y += drag.Y
+	// Update velocity
+	entity.Velocity.X += accel.X * dt
+	entity.Velocity.Y += accel.Y * dt
+	w.containSpeed(entity)
+	// Update position
+	entity.Position.X += entity.Velocity.X * dt
+	entity.Position.Y += entity.Velocity.Y * dt
+	if config.Wrap {
+		entity.Position = wrapPosition(entity.Position)
+	}
+}
+
+// Check whether a position is inside the star
+func hitsStar(pos Vector2) bool {
+	if config.NoStar {
+		return false
+	}
+	d := displacement(starPosition(), pos)
+	return math.Hypot(d.X, d.Y) < config.StarRadius
+}
+
+// Position of the star, the origin gravity and spawns are measured from
+func starPosition() Vector2 {
+	return Vector2{X: config.StarX, Y: config.StarY}
+}
+
+// Mass of the star, zero when it is disabled
+func starMass() float64 {
+	if config.NoStar {
+		return 0
+	}
+	return StarMass
+}
+
+// Describe the star for broadcasts, massless and sizeless when disabled
+func currentStar() Star {
+	if config.NoStar {
+		return Star{Position: starPosition()}
+	}
+	return Star{Position: starPosition(), Mass: StarMass, Radius: config.StarRadius}
+}
+
+// Wrap a coordinate into [-size/2, size/2)
+func wrapCoord(v, size float64) float64 {
+	v = math.Mod(v+size/2, size)
+	if v < 0 {
+		v += size
+	}
+	return v - size/2
 }
 
-// Calculate gravitational acceleration
-func gravitationalAccel(pos Vector2) Vector2 {
+// Wrap a position back into the world
+func wrapPosition(pos Vector2) Vector2 {
+	return Vector2{X: wrapCoord(pos.X, WorldWidth), Y: wrapCoord(pos.Y, WorldHeight)}
+}
+
+// Shortest displacement between two points, accounting for wrapping
+func displacement(from, to Vector2) Vector2 {
+	d := Vector2{X: to.X - from.X, Y: to.Y - from.Y}
+	if config.Wrap {
+		d = wrapPosition(d)
+	}
+	return d
+}
+
+// Round a value to the given number of decimal places
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+// Reduce the precision of an entity copy for serialization
+func quantizeEntity(entity *Entity, places int) {
+	entity.Position.X = roundTo(entity.Position.X, places)
+	entity.Position.Y = roundTo(entity.Position.Y, places)
+	entity.Velocity.X = roundTo(entity.Velocity.X, places)
+	entity.Velocity.Y = roundTo(entity.Velocity.Y, places)
+}
+
+// Gravity multiplier after running for elapsed time
+func gravityRamp(elapsed time.Duration) float64 {
+	progress := math.Min(float64(elapsed)/float64(config.GravityRampDuration), 1)
+	switch config.GravityRamp {
+	case "linear":
+		return 1 + (config.GravityRampMax-1)*progress
+	case "exponential":
+		return math.Pow(config.GravityRampMax, progress)
+	}
+	return 1
+}
+
+// Calculate gravitational acceleration in the world
+func (w *World) gravitationalAccel(pos Vector2) Vector2 {
+	wells := w.wellAccel(pos)
+	planets := w.planetAccel(pos)
+	pos = displacement(starPosition(), pos)
 	r := math.Sqrt(pos.X*pos.X + pos.Y*pos.Y)
 	if r < 0.1 { // Prevent division by zero
 		r = 0.1
 	}
-	force := -G * StarMass / (r * r)
+	force := -G * starMass() * w.gravityMultiplier / inversePower(r)
+	// Inside the safe zone gravity is held at its strength on the zone edge
+	if config.SafeRadius > 0 && r < config.SafeRadius {
+		force = -G * starMass() * w.gravityMultiplier / inversePower(config.SafeRadius)
+	}
 	unitX, unitY := pos.X/r, pos.Y/r
 	return Vector2{
-		X: force * unitX,
-		Y: force * unitY,
+		X: force*unitX + wells.X + planets.X,
+		Y: force*unitY + wells.Y + planets.Y,
 	}
 }
 
 // WebSocket handler
 func wsHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowSpawn(clientIP(r), time.Now()) {
+		http.Error(w, "too many connections, try again later", http.StatusTooManyRequests)
+		return
+	}
+	// Bound the goroutines and sockets a connection storm can tie up before
+	// clients are registered
+	release, ok := acquireSetup()
+	if !ok {
+		http.Error(w, "server busy, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Upgrade error:", err)
 		return
 	}
+	// Half-open connections never reach the simulation
+	first, ok := awaitHandshake(conn)
+	if !ok {
+		return
+	}
 
-	// Assign random position and unique ID
-	id := fmt.Sprintf("%d", time.Now().UnixNano())
-	entity := Entity{
-		ID:        id,
-		Position:  randomPosition(),
-		Velocity:  Vector2{}, // Start with zero velocity
-		Connected: true,
+	// Assign random position, and the profile's player ID or a fresh one
+	clientsMu.Lock()
+	id, profile, token := resumeProfile(playerToken(r), fmt.Sprintf("%d", time.Now().UnixNano()))
+	entity := world.newEntity(id, config.PlayerMass)
+	if profile.Name != "" {
+		entity.Name = uniqueName(profile.Name, nil)
 	}
-	entity.Velocity.Y = calculateOrbitalVelocity(StarMass, entity.Position.X)
 
 	// Register client
-	clientsMu.Lock()
-	clients[conn] = entity
+	client := newClient(conn, conn.Subprotocol() == BinarySubprotocol, entity)
+	client.compressed = config.Compression && offersCompression(r)
+	client.token = token
+	client.restoreSettings(profile.settings)
+	admitClient(client)
+	startSimulation()
 	clientsMu.Unlock()
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		client.writePump()
+	}()
+	release()
 
-	defer func() {
-		// Unregister client
+	defer client.shutdown()
+	if first != nil {
+		routeMessage(client, *first)
+	}
+
+	// Handle incoming messages on this goroutine, all writes go through the writer
+	client.readPump(conn)
+}
+
+// Apply staged runtime parameter changes at the tick boundary, the caller must
+// hold clientsMu
+func applyStaged() {
+	if stagedTimeScale != 0 {
+		world.timeScale, stagedTimeScale = stagedTimeScale, 0
+		combineBroadcasts()
+	}
+}
+
+// Invoke the tick hook, if any
+func runTickHook(snapshot []Entity) {
+	if OnTick != nil {
+		OnTick(snapshot)
+	}
+}
+
+// Bytes of the most recent snapshot broadcast, read lock-free by the REST API
+var lastSnapshot atomic.Pointer[[][]byte]
+
+// Most missed ticks replayed at once, so a long stall cannot snowball
+const MaxCatchUpTicks = 5
+
+// Physics and broadcast state, guarded by clientsMu
+var (
+	simRunning    bool
+	simStart      time.Time
+	lastDataFrame time.Time
+
+	// Time scale set at runtime and waiting for the next tick, zero when none
+	stagedTimeScale float64
+)
+
+// Start the physics and broadcast loop if it is not running, the caller must
+// hold clientsMu
+func startSimulation() {
+	if simRunning {
+		return
+	}
+	simRunning = true
+	simStart = time.Now()
+	lastDataFrame = simStart
+	go broadcastUpdates()
+}
+
+// Broadcast updates to all clients. The loop stops once the simulation has
+// been empty for the idle grace period and restarts on the next join.
+func broadcastUpdates() {
+	var emptySince time.Time
+	world.loop(runTick, func(now time.Time) bool {
 		clientsMu.Lock()
-		entity.Connected = false
-		clients[conn] = entity
-		delete(clients, conn)
-		clientsMu.Unlock()
-		conn.Close()
-	}()
+		defer clientsMu.Unlock()
+		if len(clients) > 0 || len(bots) > 0 || config.IdleGrace <= 0 {
+			emptySince = time.Time{}
+		} else if emptySince.IsZero() {
+			emptySince = now
+		} else if now.Sub(emptySince) >= config.IdleGrace {
+			simRunning = false
+			log.Println("Simulation idle, stopping physics loop")
+			return true
+		}
+		return false
+	})
+}
 
-	// Handle incoming messages (optional)
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			log.Println("Read error:", err)
-			break
+// Copy every simulated entity for a snapshot, the caller must hold clientsMu
+func snapshotEntities() []Entity {
+	var entities []Entity
+	for client := range clients {
+		if !client.Spectator {
+			entities = append(entities, client.Entity)
 		}
 	}
+	for _, bot := range bots {
+		entities = append(entities, *bot)
+	}
+	for _, projectile := range projectiles {
+		entities = append(entities, *projectile)
+	}
+	return entities
 }
 
-// Broadcast updates to all clients
-func broadcastUpdates() {
-	ticker := time.NewTicker(time.Second / 60)
-	defer ticker.Stop()
+// Advance the simulation one step and hand the resulting frames to every client.
+// The whole tick holds clientsMu. Runtime parameter changes are staged and
+// applied here before anything else, so no tick mixes old and new values.
+func runTick(now time.Time, catchUp bool) {
+	start := time.Now()
+	clientsMu.Lock()
+	applyStaged()
+	if paused {
+		// The world holds still, but clients keep seeing it
+		if !catchUp && pausedSnapshotDue(now) {
+			broadcast(now, snapshotEntities())
+		}
+		clientsMu.Unlock()
+		return
+	}
+	world.tick++
+	world.gravityMultiplier = world.physics.Gravity * gravityRamp(now.Sub(simStart))
 
-	for range ticker.C {
-		clientsMu.Lock()
+	// Update physics
+	integrated, collided := start, start
+	for _, stage := range tickStages {
+		stage.run(now)
+		switch stage.span {
+		case spanIntegration:
+			integrated = time.Now()
+		case spanCollision:
+			collided = time.Now()
+		}
+	}
 
-		// Update physics
-		for conn, entity := range clients {
-			if !entity.Connected {
-				continue
+	// Prepare update
+	entities := snapshotEntities()
+	var snapshot []Entity
+	if OnTick != nil {
+		snapshot = append([]Entity(nil), entities...)
+	}
+	prepared := time.Now()
+	if !catchUp && world.tick%uint64(broadcastDivisor) == 0 {
+		broadcast(now, entities)
+	}
+	clientsMu.Unlock()
+	done := time.Now()
+
+	if config.SlowTick > 0 && done.Sub(start) > config.SlowTick {
+		log.Printf("Warning: slow tick %d took %v with %d entities (integration %v, collision %v, broadcast %v)",
+			world.tick, done.Sub(start), len(entities), integrated.Sub(start), collided.Sub(integrated), done.Sub(prepared))
+	}
+	adjustBroadcastRate(time.Since(now))
+	runTickHook(snapshot)
+}
+
+// Serialize a snapshot and queued events and hand them to every client's
+// writer, the caller must hold clientsMu
+func broadcast(now time.Time, entities []Entity) {
+	if config.Heading {
+		for i := range entities {
+			if v := entities[i].Velocity; v != (Vector2{}) {
+				heading := math.Atan2(v.Y, v.X)
+				entities[i].Heading = &heading
 			}
-			// Calculate acceleration due to gravity
-			accel := gravitationalAccel(entity.Position)
-			// Update velocity
-			entity.Velocity.X += accel.X * TimeStep
-			entity.Velocity.Y += accel.Y * TimeStep
-			// Update position
-			entity.Position.X += entity.Velocity.X * TimeStep
-			entity.Position.Y += entity.Velocity.Y * TimeStep
-			// Store updated entity
-			clients[conn] = entity
-		}
-
-		// Prepare update
-		var entities []Entity
-		for _, entity := range clients {
-			entities = append(entities, entity)
-		}
-		update := ClientUpdate{Entities: entities}
-		data, err := json.Marshal(update)
+		}
+	}
+	if config.Precision >= 0 {
+		for i := range entities {
+			quantizeEntity(&entities[i], config.Precision)
+		}
+	}
+	update := ClientUpdate{
+		Envelope: envelope("snapshot"),
+		Star:     currentStar(),
+		Gravity:  world.gravityMultiplier,
+		Rate:     TickRate / broadcastDivisor,
+		Scale:    world.timeScale,
+		Paused:   paused,
+		Entities: entities,
+	}
+	data, err := marshalSnapshot(update)
+	if err != nil {
+		logThrottled("JSON error:", err)
+		return
+	}
+	if len(entities) == 0 {
+		data = nil
+	} else {
+		publishSnapshot(&data)
+	}
+	var eventFrames [][]byte
+	for _, event := range events {
+		eventData, err := json.Marshal(event)
 		if err != nil {
-			log.Println("JSON error:", err)
-			clientsMu.Unlock()
+			logThrottled("JSON error:", err)
 			continue
 		}
+		eventFrames = append(eventFrames, eventData)
+		countMessages("out", event.messageType(), len(clients))
+	}
+	events = nil
+	if data != nil || len(eventFrames) > 0 {
+		lastDataFrame = now
+	} else if config.HeartbeatInterval > 0 && now.Sub(lastDataFrame) >= config.HeartbeatInterval {
+		heartbeat, err := json.Marshal(HeartbeatMessage{Envelope: envelope("heartbeat"), T: now.UnixMilli()})
+		if err == nil {
+			eventFrames = append(eventFrames, heartbeat)
+			countMessages("out", "heartbeat", len(clients))
+		}
+		lastDataFrame = now
+	}
 
-		// Broadcast to all connected clients
-		for conn, entity := range clients {
-			if !entity.Connected {
-				continue
-			}
-			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
-				log.Println("Write error:", err)
-				entity.Connected = false
-				clients[conn] = entity
+	// Hand frames to each client's writer
+	targets := make([]*Client, 0, len(clients))
+	batching := false
+	for client := range clients {
+		targets = append(targets, client)
+		batching = batching || client.batchEvents
+	}
+	batched := batchedSnapshot(update, data, eventFrames, batching)
+	camera := cameraFrame()
+	refillEgress(now)
+	fanOut(targets, config.BroadcastWorkers, func(client *Client) {
+		snapshot := data
+		if snapshot != nil && !client.wantsSnapshot() {
+			snapshot = nil
+		}
+		if snapshot != nil && !client.deltas && !egressFits(frameBytes(snapshot)) {
+			snapshot = nil
+		}
+		frames := eventFrames
+		if snapshot != nil && client.deltas {
+			// Deltas are relative to the last one sent, so they queue in
+			// order where a newer snapshot cannot replace them
+			snapshot = nil
+			if delta := deltaFrame(client, update); delta != nil {
+				countMessages("out", "delta", 1)
+				frames = append([][]byte{delta}, frames...)
 			}
 		}
-		clientsMu.Unlock()
-	}
+		countMessages("out", "snapshot", len(snapshot))
+		if snapshot != nil && batched != nil && client.batchEvents {
+			// Queued in order rather than in the snapshot slot, so a newer
+			// snapshot cannot replace the events
+			snapshot, frames = nil, batched
+		}
+		if client.Spectator && camera != nil {
+			frames = append(frames[:len(frames):len(frames)], camera)
+		}
+		if preview := previewFrame(client); preview != nil {
+			frames = append(frames[:len(frames):len(frames)], preview)
+		}
+		if snapshot != nil || len(frames) > 0 {
+			chargeEgress(frameBytes(snapshot) + frameBytes(frames))
+			client.enqueue(snapshot, frames)
+		}
+	})
 }
 
 func main() {
+	parseFlags()
+	initSetupSlots()
+	world.timeScale = config.TimeScale
+	broadcastDivisor, broadcastFloor = baseDivisor(), baseDivisor()
+	combineBroadcasts()
+	logConfig()
+	checkStability()
+	openInputLog()
+	if err := loadScenario(); err != nil {
+		log.Fatal("Scenario error: ", err)
+	}
+	startWebhooks()
+	upgrader.EnableCompression = config.Compression
+
 	// Seed random number generator
 	rand.Seed(time.Now().UnixNano())
 
 	// Start physics and broadcast loop
-	go broadcastUpdates()
+	clientsMu.Lock()
+	startSimulation()
+	clientsMu.Unlock()
+	startBenchWorlds()
+	if config.IdleTimeout > 0 {
+		go idleSweeper()
+	}
+	go profileSweeper()
+	if config.Autosave != "" {
+		go autosave()
+	}
 
 	// Set up WebSocket endpoint
 	http.HandleFunc("/ws", wsHandler)
 
+	// Read-only snapshot stream for consumers without websockets
+	http.HandleFunc("GET /sse", sseHandler)
+
+	// REST API
+	http.HandleFunc("GET /api/field", fieldHandler)
+	http.HandleFunc("POST /api/bots", botsHandler)
+	http.HandleFunc("GET /api/predict/approach", approachHandler)
+	http.HandleFunc("GET /api/debug", requireAdmin(debugHandler))
+	http.HandleFunc("GET /api/config", configHandler)
+	http.HandleFunc("GET /api/potential", potentialHandler)
+	http.HandleFunc("GET /api/snapshot", snapshotHandler)
+	http.HandleFunc("GET /api/export", exportHandler)
+	http.HandleFunc("GET /api/com", comHandler)
+	http.HandleFunc("GET /api/angular-momentum", angularMomentumHandler)
+	http.HandleFunc("GET /stats", statsHandler)
+	http.HandleFunc("GET /api/pipeline", pipelineHandler)
+	http.HandleFunc("GET /api/bench", benchHandler)
+	http.HandleFunc("GET /api/entities", entitiesHandler)
+	http.HandleFunc("PUT /api/entities", requireAdmin(bulkUpdateHandler))
+	http.HandleFunc("POST /api/timescale", requireAdmin(timeScaleHandler))
+	http.HandleFunc("GET /api/scores", scoresHandler)
+	http.HandleFunc("GET /api/near", nearHandler)
+	http.HandleFunc("GET /api/aggregate", aggregateHandler)
+	http.HandleFunc("POST /api/god/position", requireAdmin(godPositionHandler))
+	http.HandleFunc("DELETE /api/god", requireAdmin(godRemoveHandler))
+	http.HandleFunc("POST /api/pause", requireAdmin(pauseHandler(true)))
+	http.HandleFunc("POST /api/resume", requireAdmin(pauseHandler(false)))
+	http.HandleFunc("GET /api/entities/{id}/soi", soiHandler)
+	http.HandleFunc("POST /api/scores/reset", requireAdmin(resetScoresHandler))
+	http.HandleFunc("GET /metrics", metricsHandler)
+	http.HandleFunc("GET /api/probe/neighbors", requireAdmin(neighborsHandler))
+	http.HandleFunc("POST /api/announce", requireAdmin(announceHandler))
+	http.HandleFunc("POST /api/entities/{id}/freeze", requireAdmin(freezeHandler(true)))
+	http.HandleFunc("POST /api/entities/{id}/unfreeze", requireAdmin(freezeHandler(false)))
+	http.HandleFunc("POST /api/entities/{id}/mass", requireAdmin(massHandler))
+
 	// Serve a simple HTTP page for testing
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		issuePlayerCookie(w, r)
 		fmt.Fprintf(w, `
 			<!DOCTYPE html>
 			<html>
@@ -197,31 +895,79 @@ func main() {
 			<body>
 				<h1>WebSocket 2D Gravitational Simulation</h1>
 				<canvas id="canvas" width="800" height="600"></canvas>
+				<p>Arrow keys fly your ship: up fires the engine, left and right turn.</p>
 				<script>
-					const ws = new WebSocket("ws://" + window.location.host + "/ws");
+					const scheme = window.location.protocol === "https:" ? "wss://" : "ws://";
+					const ws = new WebSocket(scheme + window.location.host + "/ws");
 					const canvas = document.getElementById("canvas");
 					const ctx = canvas.getContext("2d");
+					let myId = null;
+					let planets = [];
+					fetch("/api/config").then(r => r.json()).then(c => planets = c.planets || []);
+
+					// The canvas draws +Y downwards, so positive rotate turns clockwise on screen
+					const keys = {ArrowUp: false, ArrowLeft: false, ArrowRight: false};
+					const sendInput = () => ws.send(JSON.stringify({
+						type: "input",
+						thrust: keys.ArrowUp,
+						rotate: (keys.ArrowRight ? 1 : 0) - (keys.ArrowLeft ? 1 : 0),
+					}));
+					const onKey = (down) => (e) => {
+						if (!(e.key in keys)) return;
+						e.preventDefault();
+						if (keys[e.key] === down || ws.readyState !== WebSocket.OPEN) return;
+						keys[e.key] = down;
+						sendInput();
+					};
+					window.addEventListener("keydown", onKey(true));
+					window.addEventListener("keyup", onKey(false));
 
-					ws.onopen = () => console.log("Connected to server");
+					ws.onopen = () => {
+						console.log("Connected to server");
+						ws.send(JSON.stringify({type: "join"}));
+					};
 					ws.onclose = () => console.log("Disconnected");
 					ws.onmessage = (e) => {
 						const data = JSON.parse(e.data);
+						if (data.v !== 1) {
+							console.log("Unsupported protocol version", data.v);
+							return;
+						}
+						if (data.type === "welcome") myId = data.id;
+						if (data.type !== "snapshot") return;
 						// console.log(data);
 						ctx.clearRect(0, 0, canvas.width, canvas.height);
 						// Draw star at center
 						ctx.fillStyle = "red";
 						ctx.beginPath();
-						ctx.arc(canvas.width/2, canvas.height/2, 10, 0, 2*Math.PI);
+						ctx.arc(canvas.width/2 + data.star.position.X, canvas.height/2 + data.star.position.Y, data.star.radius, 0, 2*Math.PI);
 						ctx.fill();
+						ctx.fillStyle = "gray";
+						planets.forEach(p => {
+							ctx.beginPath();
+							ctx.arc(canvas.width/2 + p.position.X, canvas.height/2 + p.position.Y, p.radius, 0, 2*Math.PI);
+							ctx.fill();
+						});
 						// Draw entities
 						data.entities.forEach(entity => {
-							// The connected entity check isn't working as intended, so commented out for now
-							// if (entity.connected != true) return;
+							if (entity.State !== "alive") return;
 							const x = canvas.width/2 + entity.Position.X;
 							const y = canvas.height/2 + entity.Position.Y;
+							if (entity.ID === myId) {
+								// Own ship, a triangle pointing along its orientation
+								const r = entity.Radius * 1.5;
+								const a = entity.Orientation || 0;
+								ctx.fillStyle = "green";
+								ctx.beginPath();
+								ctx.moveTo(x + r*Math.cos(a), y + r*Math.sin(a));
+								ctx.lineTo(x + r*Math.cos(a + 2.5), y + r*Math.sin(a + 2.5));
+								ctx.lineTo(x + r*Math.cos(a - 2.5), y + r*Math.sin(a - 2.5));
+								ctx.fill();
+								return;
+							}
 							ctx.fillStyle = "blue";
 							ctx.beginPath();
-							ctx.arc(x, y, 5, 0, 2*Math.PI);
+							ctx.arc(x, y, entity.Radius, 0, 2*Math.PI);
 							ctx.fill();
 						});
 					};
@@ -232,8 +978,24 @@ func main() {
 	})
 
 	// Start server
-	log.Println("Server starting on :8080...")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	log.Println("Server starting on " + config.Addr + "...")
+	notify("start", nil)
+	// HTTP/2 is negotiated automatically over TLS. Websockets keep using the
+	// HTTP/1.1 upgrade, which clients fall back to on their own connection.
+	server := &http.Server{Addr: config.Addr, Handler: countProtocols(http.DefaultServeMux)}
+	stopped := make(chan struct{})
+	if config.MaxRuntime > 0 {
+		go enforceRuntime(server, stopped)
+	}
+	var err error
+	if config.TLSCert != "" {
+		err = server.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal("ListenAndServe:", err)
 	}
+	// Only the runtime cap closes the server, let it finish flushing clients
+	<-stopped
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn records the frames a client's writer sends instead of writing
+// them to a socket
+type fakeConn struct {
+	mu     sync.Mutex
+	frames [][]byte
+	closed bool
+}
+
+func (f *fakeConn) WriteMessage(messageType int, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.frames = append(f.frames, append([]byte(nil), data...))
+	return nil
+}
+
+func (f *fakeConn) SetWriteDeadline(time.Time) error { return nil }
+func (f *fakeConn) EnableWriteCompression(bool)      {}
+
+func (f *fakeConn) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+// Wait for a frame of the given message type and decode it into v
+func (f *fakeConn) await(t *testing.T, msgType string, v any) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		frames := f.frames
+		f.mu.Unlock()
+		for _, frame := range frames {
+			var env Envelope
+			if json.Unmarshal(frame, &env) == nil && env.Type == msgType {
+				if err := json.Unmarshal(frame, v); err != nil {
+					t.Fatalf("decoding %s: %v", msgType, err)
+				}
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no %s frame received", msgType)
+}
+
+// Connect a client over a fake socket with its writer running, removed
+// again when the test ends
+func connectFake(t *testing.T, id string) (*Client, *fakeConn) {
+	t.Helper()
+	conn := &fakeConn{}
+	client := newClient(conn, false, world.newEntity(id, config.PlayerMass))
+	clientsMu.Lock()
+	admitClient(client)
+	clientsMu.Unlock()
+	go client.writePump()
+	t.Cleanup(client.shutdown)
+	return client, conn
+}
+
+func TestTickBroadcastsSnapshot(t *testing.T) {
+	a, connA := connectFake(t, "fake-a")
+	_, connB := connectFake(t, "fake-b")
+	before := a.Entity.Position
+
+	runTick(time.Now(), false)
+
+	for _, conn := range []*fakeConn{connA, connB} {
+		var update ClientUpdate
+		conn.await(t, "snapshot", &update)
+		if update.V != ProtocolVersion {
+			t.Errorf("snapshot version %d, want %d", update.V, ProtocolVersion)
+		}
+		if update.Star.Mass != StarMass {
+			t.Errorf("star mass %g, want %d", update.Star.Mass, StarMass)
+		}
+		seen := make(map[string]Entity)
+		for _, e := range update.Entities {
+			seen[e.ID] = e
+		}
+		for _, id := range []string{"fake-a", "fake-b"} {
+			e, ok := seen[id]
+			if !ok {
+				t.Fatalf("snapshot is missing %s", id)
+			}
+			if e.State != StateAlive {
+				t.Errorf("%s state %q, want alive", id, e.State)
+			}
+		}
+		if seen["fake-a"].Position == before {
+			t.Error("entity did not move during the tick")
+		}
+	}
+}
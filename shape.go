@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Shape is an entity's collision shape
+type Shape string
+
+// Collision shapes. A capsule is the entity's radius swept along a segment
+// of its Length through its position, aligned with its velocity.
+const (
+	ShapeCircle  Shape = "" // Default, the entity's radius around its position
+	ShapeCapsule Shape = "capsule"
+)
+
+// Longest capsule segment
+const MaxShapeLength = 100
+
+// Check a shape and its segment length
+func validShape(shape Shape, length float64) error {
+	switch {
+	case shape != ShapeCircle && shape != ShapeCapsule:
+		return fmt.Errorf("unknown shape %q", shape)
+	case shape == ShapeCircle && length != 0:
+		return fmt.Errorf("circles have no length")
+	case shape == ShapeCapsule && (!isFinite(length) || length <= 0 || length > MaxShapeLength):
+		return fmt.Errorf("capsule length must be in (0, %d]", MaxShapeLength)
+	}
+	return nil
+}
+
+// Half of an entity's collision segment, from its position to one end. Zero
+// for circles, and along +X for a capsule at rest.
+func halfSegment(e *Entity) Vector2 {
+	if e.Shape != ShapeCapsule {
+		return Vector2{}
+	}
+	axis := unit(e.Velocity)
+	if axis == (Vector2{}) {
+		axis = Vector2{X: 1}
+	}
+	half := e.Length / 2 * config.CollisionRadiusScale
+	return Vector2{X: axis.X * half, Y: axis.Y * half}
+}
+
+// Distance from an entity's position to the furthest point of its hitbox
+func boundRadius(e *Entity) float64 {
+	return hitRadius(e) + e.Length/2*config.CollisionRadiusScale
+}
+
+// Closest points between the segments of two entities, as the unit normal
+// from a's point towards b's, the distance between them, and a's contact
+// point on its surface. Circles are segments of zero length, so every pair
+// of shapes goes through the same segment-segment test.
+func contact(a, b *Entity) (Vector2, float64, Vector2) {
+	ha, hb := halfSegment(a), halfSegment(b)
+	d := displacement(a.Position, b.Position)
+	// Segments relative to a's position, p1 + s d1 and p2 + t d2 for s, t in [0, 1]
+	p1 := Vector2{X: -ha.X, Y: -ha.Y}
+	d1 := Vector2{X: 2 * ha.X, Y: 2 * ha.Y}
+	p2 := Vector2{X: d.X - hb.X, Y: d.Y - hb.Y}
+	d2 := Vector2{X: 2 * hb.X, Y: 2 * hb.Y}
+	s, t := closestParams(p1, d1, p2, d2)
+
+	c1 := Vector2{X: p1.X + s*d1.X, Y: p1.Y + s*d1.Y}
+	c2 := Vector2{X: p2.X + t*d2.X, Y: p2.Y + t*d2.Y}
+	gap := Vector2{X: c2.X - c1.X, Y: c2.Y - c1.Y}
+	dist := math.Hypot(gap.X, gap.Y)
+	n := Vector2{X: 1}
+	if dist > 0 {
+		n = Vector2{X: gap.X / dist, Y: gap.Y / dist}
+	} else if centers := unit(d); centers != (Vector2{}) {
+		n = centers
+	}
+	r := hitRadius(a)
+	point := Vector2{X: a.Position.X + c1.X + n.X*r, Y: a.Position.Y + c1.Y + n.Y*r}
+	return n, dist, point
+}
+
+// Parameters of the closest points between segments p1 + s d1 and p2 + t d2,
+// following Ericson's Real-Time Collision Detection, 5.1.9
+func closestParams(p1, d1, p2, d2 Vector2) (float64, float64) {
+	const eps = 1e-12
+	clamp := func(v float64) float64 { return math.Max(0, math.Min(1, v)) }
+	dot := func(u, v Vector2) float64 { return u.X*v.X + u.Y*v.Y }
+
+	r := Vector2{X: p1.X - p2.X, Y: p1.Y - p2.Y}
+	a, e, f := dot(d1, d1), dot(d2, d2), dot(d2, r)
+	switch {
+	case a <= eps && e <= eps:
+		return 0, 0
+	case a <= eps:
+		return 0, clamp(f / e)
+	}
+	c := dot(d1, r)
+	if e <= eps {
+		return clamp(-c / a), 0
+	}
+	b := dot(d1, d2)
+	s := 0.0
+	if denom := a*e - b*b; denom > eps {
+		s = clamp((b*f - c*e) / denom)
+	}
+	t := (b*s + f) / e
+	switch {
+	case t < 0:
+		return clamp(-c / a), 0
+	case t > 1:
+		return clamp((b - c) / a), 1
+	}
+	return s, t
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+)
+
+// Ship controls
+const (
+	ShipThrust   = MaxThrust // Engine acceleration along the ship's orientation
+	ShipTurnRate = math.Pi   // Radians per second at full rotate
+)
+
+// helm is the latest ship-style control state sent in input messages
+type helm struct {
+	engaged bool    // Input messages fly the entity, until a thrust message or the autopilot takes over
+	burn    bool    // Engine firing
+	rotate  float64 // Turn rate in [-1, 1], positive counterclockwise
+}
+
+// Buffer ship controls, {"type":"input","thrust":true,"rotate":-1}, in the
+// same timestamped queue as thrust. They take effect on the tick they are due
+// and stay in effect every tick until the next input message.
+func handleInput(client *Client, msg ClientMessage) bool {
+	at, ok := inputTime(msg)
+	if !ok {
+		log.Println("Message error: input timestamp out of range")
+		return false
+	}
+	if !isFinite(msg.Rotate) {
+		log.Println("Message error: invalid rotate")
+		return false
+	}
+	rotate := math.Max(-1, math.Min(1, msg.Rotate))
+	var burn bool
+	if msg.Thrust != nil && json.Unmarshal(msg.Thrust, &burn) != nil {
+		log.Println("Message error: invalid input thrust")
+		return false
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	client.queueInput(ThrustInput{At: at, Helm: &helm{engaged: true, burn: burn, rotate: rotate}})
+	client.send(AckMessage{Envelope: envelope("ack"), T: msg.T})
+	return true
+}
+
+// Turn the ship and point its engine along its orientation for this tick,
+// the caller must hold clientsMu
+func (c *Client) steerHelm() {
+	if !c.helm.engaged {
+		return
+	}
+	e := &c.Entity
+	e.Orientation = math.Remainder(e.Orientation+c.helm.rotate*ShipTurnRate*world.timeStep(), 2*math.Pi)
+	c.thrustTarget = Vector2{}
+	if c.helm.burn {
+		c.thrustTarget = Vector2{X: math.Cos(e.Orientation) * ShipThrust, Y: math.Sin(e.Orientation) * ShipThrust}
+	}
+}
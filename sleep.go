@@ -0,0 +1,48 @@
+package main
+
+import "math"
+
+// Sleep heuristic parameters
+const (
+	SleepDelay     = TickRate // Quiet ticks before a bot sleeps
+	SleepTolerance = 1e-3     // Relative speed change per tick still counted as steady
+)
+
+// Put bots to sleep once they have coasted at a steady speed with nothing
+// nearby for SleepDelay ticks, and wake any that thrust, speed up or slow
+// down, or have company. Players stay awake so their own ship moves
+// smoothly. The caller must hold clientsMu.
+func updateSleep(all []*Entity) {
+	if config.SleepStride < 2 {
+		return
+	}
+	grid := newSpatialGrid(all)
+	for _, bot := range bots {
+		speed := math.Hypot(bot.Velocity.X, bot.Velocity.Y)
+		// A sleeping bot's speed changes once per longer step
+		limit := SleepTolerance * speed
+		if bot.sleeping {
+			limit *= float64(config.SleepStride)
+		}
+		quiet := bot.alive() && !bot.Static && bot.Group == "" &&
+			bot.Thrust == (Vector2{}) && math.Abs(speed-bot.lastSpeed) <= limit
+		bot.lastSpeed = speed
+		if quiet && world.nearWell(bot.Position, config.SleepRadius) {
+			quiet = false
+		}
+		if quiet {
+			grid.near(bot.Position, config.SleepRadius, all, func(other *Entity, _ float64) {
+				if other != bot && other.alive() {
+					quiet = false
+				}
+			})
+		}
+		if !quiet {
+			bot.sleeping, bot.quietTicks = false, 0
+			continue
+		}
+		// Capped, a bot may coast asleep for the life of the server
+		bot.quietTicks = min(bot.quietTicks+1, SleepDelay)
+		bot.sleeping = bot.quietTicks >= SleepDelay
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Tracked IPs above which buckets that have refilled are forgotten
+const MaxSpawnBuckets = 10000
+
+// Longest a connection waits for a setup slot before it is turned away
+const SetupWait = 2 * time.Second
+
+// Slots for connections between accept and registration, nil when unlimited
+var setupSlots chan struct{}
+
+// Create the setup slots for the configured limit
+func initSetupSlots() {
+	if config.MaxConnectionSetups > 0 {
+		setupSlots = make(chan struct{}, config.MaxConnectionSetups)
+	}
+}
+
+// Take a setup slot, queueing briefly when all are busy. The release func is
+// safe to call more than once.
+func acquireSetup() (func(), bool) {
+	if setupSlots == nil {
+		return func() {}, true
+	}
+	select {
+	case setupSlots <- struct{}{}:
+	default:
+		timer := time.NewTimer(SetupWait)
+		defer timer.Stop()
+		select {
+		case setupSlots <- struct{}{}:
+		case <-timer.C:
+			return nil, false
+		}
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-setupSlots }) }, true
+}
+
+// Per-IP connection buckets, guarded by spawnMu
+var (
+	spawnBuckets = make(map[string]*tokenBucket)
+	spawnMu      sync.Mutex
+)
+
+// Address a request came from, without the port. Proxy headers are not
+// trusted.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Take a connection token for an IP, reporting whether it may connect
+func allowSpawn(ip string, now time.Time) bool {
+	if config.SpawnRate <= 0 {
+		return true
+	}
+	burst := float64(config.SpawnBurst)
+
+	spawnMu.Lock()
+	defer spawnMu.Unlock()
+	if len(spawnBuckets) >= MaxSpawnBuckets {
+		for key, b := range spawnBuckets {
+			if b.tokens+now.Sub(b.last).Seconds()*config.SpawnRate >= burst {
+				delete(spawnBuckets, key)
+			}
+		}
+	}
+	b := spawnBuckets[ip]
+	if b == nil {
+		b = &tokenBucket{}
+		spawnBuckets[ip] = b
+	}
+	b.refillTo(now, config.SpawnRate, burst)
+	return b.take(1)
+}
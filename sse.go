@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Interval between comment lines on an idle event stream, so proxies
+// do not close it while no snapshots are broadcast
+const SSEKeepAlive = 15 * time.Second
+
+// Closed and replaced whenever a new snapshot is stored, guarded by
+// snapshotReadyMu
+var (
+	snapshotReady   = make(chan struct{})
+	snapshotReadyMu sync.Mutex
+)
+
+// Store the latest snapshot and wake every event stream waiting for one
+func publishSnapshot(data *[][]byte) {
+	lastSnapshot.Store(data)
+	snapshotReadyMu.Lock()
+	close(snapshotReady)
+	snapshotReady = make(chan struct{})
+	snapshotReadyMu.Unlock()
+}
+
+// Channel closed when the next snapshot is stored
+func nextSnapshot() <-chan struct{} {
+	snapshotReadyMu.Lock()
+	defer snapshotReadyMu.Unlock()
+	return snapshotReady
+}
+
+// Stream snapshots as server-sent events, one "snapshot" event per frame,
+// for read-only consumers without websockets. An optional hz parameter
+// subscribes to fewer snapshots per second, as the rate message does. With
+// view=aggregate each snapshot is replaced by an "aggregate" event of the
+// density and flow field, in cells of the optional cell parameter.
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	view := r.URL.Query().Get("view")
+	if view != "" && view != "snapshot" && view != "aggregate" {
+		http.Error(w, "view must be snapshot or aggregate", http.StatusBadRequest)
+		return
+	}
+	cellSize, ok := aggregateCellSize(r)
+	if !ok {
+		http.Error(w, "cell must be a number of at least "+strconv.Itoa(MinAggregateCell), http.StatusBadRequest)
+		return
+	}
+	every := time.Duration(0)
+	if s := r.URL.Query().Get("hz"); s != "" {
+		hz, err := strconv.ParseFloat(s, 64)
+		if err != nil || !isFinite(hz) || hz < 1 || hz > TickRate {
+			http.Error(w, "hz must be between 1 and "+strconv.Itoa(TickRate), http.StatusBadRequest)
+			return
+		}
+		every = time.Duration(math.Round(TickRate/hz)) * time.Second / TickRate
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		logThrottled("Write error:", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(SSEKeepAlive)
+	defer keepAlive.Stop()
+	var sent time.Time
+	ready := nextSnapshot()
+	for {
+		var frames [][]byte
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			frames = [][]byte{[]byte(": keepalive\n\n")}
+		case <-ready:
+			ready = nextSnapshot()
+			// Half a tick of slack keeps scheduling jitter from skipping one
+			if time.Since(sent) < every-time.Second/TickRate/2 {
+				continue
+			}
+			if view == "aggregate" {
+				frame, err := aggregateFrame(cellSize)
+				if err != nil {
+					logThrottled("JSON error:", err)
+					continue
+				}
+				sent = time.Now()
+				countMessages("out", "aggregate", 1)
+				frames = [][]byte{frame}
+				break
+			}
+			data := lastSnapshot.Load()
+			if data == nil {
+				continue
+			}
+			sent = time.Now()
+			countMessages("out", "snapshot", len(*data))
+			for _, page := range *data {
+				frames = append(frames, []byte("event: snapshot\ndata: "+string(page)+"\n\n"))
+			}
+		}
+
+		rc.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+		for _, frame := range frames {
+			if _, err := w.Write(frame); err != nil {
+				logThrottled("Write error:", err)
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			logThrottled("Write error:", err)
+			return
+		}
+	}
+}
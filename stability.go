@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"math"
+)
+
+// Startup stability check parameters
+const (
+	StabilityRadius = (MinDistance + MaxDistance) / 2 // Reference orbit, mid spawn range
+	StabilityOrbits = 10                              // Orbits simulated
+)
+
+// Simulate a reference circular orbit with the configured parameters and
+// report the relative drift in its specific orbital energy
+func energyDrift() float64 {
+	star := starPosition()
+	entity := Entity{
+		Position: Vector2{X: star.X + StabilityRadius, Y: star.Y},
+		Velocity: Vector2{Y: calculateOrbitalVelocity(starMass()*world.gravityMultiplier, StabilityRadius)},
+		Mass:     1,
+		Radius:   entityRadius(1),
+		State:    StateAlive,
+	}
+	start := specificEnergy(entity)
+	// Circular orbits of steep force laws have zero energy, so drift is
+	// measured against the kinetic energy when that is larger
+	scale := math.Max(math.Abs(start), entity.Velocity.Y*entity.Velocity.Y/2)
+	period := 2 * math.Pi * StabilityRadius / entity.Velocity.Y
+	steps := int(StabilityOrbits * period / world.timeStep())
+	for i := 0; i < steps && !hitsStar(entity.Position); i++ {
+		world.step(&entity)
+	}
+	if hitsStar(entity.Position) {
+		return math.Inf(1)
+	}
+	return math.Abs(specificEnergy(entity)-start) / scale
+}
+
+// Kinetic plus potential energy per unit mass under the star's force law
+func specificEnergy(entity Entity) float64 {
+	v := entity.Velocity
+	return (v.X*v.X+v.Y*v.Y)/2 + world.starPotential(entity.Position)
+}
+
+// Warn, or refuse to start in strict mode, when the time step is too coarse
+// for the configured gravity to hold a reference orbit
+func checkStability() {
+	if config.NoStar || config.StabilityTolerance <= 0 {
+		return
+	}
+	drift := energyDrift()
+	if drift <= config.StabilityTolerance {
+		return
+	}
+	if config.StrictStability {
+		log.Fatalf("Config error: reference orbit energy drifted %.3g%% over %d orbits, above %.3g%%",
+			drift*100, StabilityOrbits, config.StabilityTolerance*100)
+	}
+	log.Printf("Warning: reference orbit energy drifted %.3g%% over %d orbits, above %.3g%%",
+		drift*100, StabilityOrbits, config.StabilityTolerance*100)
+}
@@ -0,0 +1,46 @@
+package main
+
+// EntityState is the game lifecycle state of an entity, independent of the
+// network connection behind it
+type EntityState string
+
+// Entity lifecycle states
+const (
+	StateAlive      EntityState = "alive"
+	StateDead       EntityState = "dead"
+	StateRespawning EntityState = "respawning"
+	StateSpectating EntityState = "spectating"
+)
+
+// Ticks an entity waits between dying and respawning
+const RespawnDelay = 2 * TickRate
+
+// Take an entity out of play until it respawns, the caller must hold w.mu
+func (w *World) kill(entity *Entity) {
+	entity.State = StateRespawning
+	entity.respawnTick = w.tick + RespawnDelay
+	entity.Velocity = Vector2{}
+	entity.Thrust = Vector2{}
+}
+
+// Put an entity back into play on a fresh orbit in the world
+func (w *World) respawn(entity *Entity) {
+	entity.Mass += entity.burned
+	entity.Radius = entityRadius(entity.Mass)
+	entity.burned = 0
+	fresh := w.newEntity(entity.ID, entity.Mass)
+	entity.Position = fresh.Position
+	entity.Velocity = fresh.Velocity
+	entity.Fuel = fresh.Fuel
+	entity.Health = fresh.Health
+	if config.CollisionDamage > 0 {
+		entity.protectedTick = w.tick + uint64(config.SpawnProtection.Seconds()*TickRate)
+	}
+	entity.Phase, entity.phaseSet = 0, false
+	entity.State = StateAlive
+}
+
+// Report whether an entity takes part in physics
+func (e *Entity) alive() bool {
+	return e.State == StateAlive
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Webhook delivery limits
+const (
+	WebhookQueueSize = 64              // Notifications buffered before new ones are dropped
+	WebhookRetries   = 3               // Delivery attempts after the first
+	WebhookBackoff   = time.Second     // Delay before the first retry, doubled each time
+	WebhookTimeout   = 5 * time.Second // Per-request timeout
+)
+
+// WebhookEvent is the JSON body posted to the webhook URL
+type WebhookEvent struct {
+	Event string      `json:"event"`
+	Time  int64       `json:"time"` // Unix milliseconds
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Pending notifications, nil when no webhook is configured
+var webhooks chan WebhookEvent
+
+// Start the webhook dispatcher if a URL is configured
+func startWebhooks() {
+	if config.WebhookURL == "" {
+		return
+	}
+	webhooks = make(chan WebhookEvent, WebhookQueueSize)
+	go dispatchWebhooks()
+}
+
+// Queue a notification without blocking, dropping it if the queue is full
+func notify(event string, data interface{}) {
+	if webhooks == nil {
+		return
+	}
+	select {
+	case webhooks <- WebhookEvent{Event: event, Time: time.Now().UnixMilli(), Data: data}:
+	default:
+		log.Println("Webhook error: queue full, dropping", event)
+	}
+}
+
+// Deliver queued notifications one at a time, retrying failures with backoff
+func dispatchWebhooks() {
+	client := &http.Client{Timeout: WebhookTimeout}
+	for event := range webhooks {
+		body, err := json.Marshal(event)
+		if err != nil {
+			logThrottled("JSON error:", err)
+			continue
+		}
+		backoff := WebhookBackoff
+		for attempt := 0; ; attempt++ {
+			err = postWebhook(client, body)
+			if err == nil || attempt == WebhookRetries {
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err != nil {
+			log.Println("Webhook error:", err)
+		}
+	}
+}
+
+// Post one notification body
+func postWebhook(client *http.Client, body []byte) error {
+	resp, err := client.Post(config.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
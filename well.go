@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Gravity well parameters
+const (
+	MaxWells        = 16            // Wells alive across the server
+	MaxWellMass     = StarMass / 10 // Heaviest well, a tenth of the star
+	MaxWellTTL      = 30            // Seconds a well may last
+	WellCooldown    = time.Second   // Minimum time between wells per client
+	WellMinDistance = 10            // Distance below which a well's pull stops growing
+)
+
+// gravityWell is a temporary attractor placed by a client
+type gravityWell struct {
+	id        string
+	position  Vector2
+	mass      float64
+	expiresAt time.Time
+}
+
+// Gravity wells placed so far, for their IDs, guarded by clientsMu. The
+// wells themselves belong to the main world.
+var wellSeq uint64
+
+// GravityWellEvent is sent when a gravity well is placed
+type GravityWellEvent struct {
+	Envelope
+	ID       string  `json:"id"`
+	Position Vector2 `json:"position"`
+	Mass     float64 `json:"mass"`
+	TTL      float64 `json:"ttl"` // Seconds until it expires
+}
+
+// GravityWellEndEvent is sent when a gravity well expires
+type GravityWellEndEvent struct {
+	Envelope
+	ID string `json:"id"`
+}
+
+// Place a gravity well that pulls on every entity until its TTL runs out
+func handleGravityWell(client *Client, msg ClientMessage) bool {
+	if !isFinite(msg.X) || !isFinite(msg.Y) {
+		log.Println("Message error: invalid well position")
+		return false
+	}
+	if !isFinite(msg.Mass) || msg.Mass <= 0 || msg.Mass > MaxWellMass {
+		log.Println("Message error: well mass must be in (0,", MaxWellMass, "]")
+		return false
+	}
+	if !isFinite(msg.TTL) || msg.TTL <= 0 || msg.TTL > MaxWellTTL {
+		log.Println("Message error: well ttl must be in (0,", MaxWellTTL, "] seconds")
+		return false
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	now := time.Now()
+	if now.Sub(client.lastWell) < WellCooldown {
+		log.Println("Message error: gravity well cooldown")
+		return false
+	}
+	if len(world.wells) >= MaxWells {
+		log.Println("Message error: gravity well limit reached")
+		return false
+	}
+	client.lastWell = now
+
+	wellSeq++
+	well := &gravityWell{
+		id:        fmt.Sprintf("well-%d", wellSeq),
+		position:  Vector2{X: msg.X, Y: msg.Y},
+		mass:      msg.Mass,
+		expiresAt: now.Add(time.Duration(msg.TTL * float64(time.Second))),
+	}
+	world.wells = append(world.wells, well)
+	emitEvent(GravityWellEvent{Envelope: envelope("gravity_well"), ID: well.id,
+		Position: well.position, Mass: well.mass, TTL: msg.TTL})
+	return true
+}
+
+// Remove expired wells, the caller must hold clientsMu
+func pruneWells(now time.Time) {
+	kept := world.wells[:0]
+	for _, well := range world.wells {
+		if now.After(well.expiresAt) {
+			emitEvent(GravityWellEndEvent{Envelope: envelope("gravity_well_end"), ID: well.id})
+			continue
+		}
+		kept = append(kept, well)
+	}
+	clear(world.wells[len(kept):])
+	world.wells = kept
+}
+
+// Acceleration at pos from every gravity well in the world, under the star's
+// force law and the world's gravity multiplier. The caller must hold w.mu.
+func (w *World) wellAccel(pos Vector2) Vector2 {
+	var accel Vector2
+	for _, well := range w.wells {
+		d := displacement(pos, well.position)
+		r := math.Hypot(d.X, d.Y)
+		if r == 0 {
+			continue
+		}
+		k := G * well.mass * w.gravityMultiplier / inversePower(math.Max(r, WellMinDistance)) / r
+		accel.X += k * d.X
+		accel.Y += k * d.Y
+	}
+	return accel
+}
+
+// Report whether a gravity well in the world is within radius of pos, the
+// caller must hold w.mu
+func (w *World) nearWell(pos Vector2, radius float64) bool {
+	for _, well := range w.wells {
+		d := displacement(pos, well.position)
+		if math.Hypot(d.X, d.Y) <= radius {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Physics holds the parameters a world's physics runs under. The main
+// world's come from the flags.
+type Physics struct {
+	// Pairwise gravity between entities, skipping pairs further apart than
+	// GravityCutoff when it is positive, softened over Softening
+	NBody         bool
+	GravityCutoff float64
+	Softening     float64
+
+	// Simulated seconds per real second at startup, adjustable at runtime
+	TimeScale float64
+
+	// Strength of the star's, planets' and wells' gravity, which the main
+	// world's difficulty ramp multiplies further
+	Gravity float64
+
+	// Coefficient of restitution, 1 bounces elastically and 0 sticks
+	Restitution float64
+
+	// Collision resolution passes per tick, stopping early once the deepest
+	// remaining overlap is below CollisionSlop
+	CollisionIterations int
+	CollisionSlop       float64
+}
+
+// World is one simulation: the entities and gravity wells in it, the
+// physics parameters it runs under, the state its physics carries from tick
+// to tick, and the lock guarding all of that. The game runs in the main
+// world, where clients, bots and projectiles live. Other worlds hold their
+// own entities and run the same physics on their own loop.
+type World struct {
+	mu      *sync.Mutex
+	physics *Physics // Read only once the world runs
+
+	// Simulated entities, collected by collect when it is set, such as the
+	// main world's clients and bots, and otherwise the world's own list
+	collect  func() []*Entity
+	entities []*Entity
+
+	// Hooks for the game around the physics, nil in a world without one:
+	// emit queues an event and impact applies the effects of a collision
+	emit   func(Message)
+	impact func(a, b *Entity, impulse float64)
+
+	// Physics state, guarded by mu
+	tick              uint64  // Physics steps taken since the world started
+	timeScale         float64 // Simulated seconds per real second
+	gravityMultiplier float64 // Current gravity difficulty multiplier
+	wells             []*gravityWell
+	contacts          map[[2]string]uint64 // Last tick each pair of entity IDs, in order, was in contact
+	tunnelSteps       int                  // Tunneling steps since the last warning
+	lastTunnelWarn    time.Time
+
+	missed atomic.Uint64 // Ticks the loop's ticker dropped because it fell behind
+}
+
+// The main world, guarded by clientsMu
+var world = newWorld(&clientsMu, &config.Physics)
+
+// Hook the game into the main world. Assigned here rather than in the
+// declaration since the hooks refer back to the world.
+func init() {
+	world.collect, world.emit, world.impact = bodies, emitEvent, collisionEffects
+}
+
+// Create an empty world running under the given lock and physics
+func newWorld(mu *sync.Mutex, physics *Physics) *World {
+	return &World{
+		mu:                mu,
+		physics:           physics,
+		timeScale:         physics.TimeScale,
+		gravityMultiplier: physics.Gravity,
+		contacts:          make(map[[2]string]uint64),
+	}
+}
+
+// Collect every simulated entity, the caller must hold w.mu
+func (w *World) bodies() []*Entity {
+	if w.collect != nil {
+		return w.collect()
+	}
+	return w.entities
+}
+
+// Create the envelope for a message about this world, the caller must hold
+// w.mu
+func (w *World) envelope(messageType string) Envelope {
+	return Envelope{V: ProtocolVersion, Type: messageType, Tick: w.tick}
+}
+
+// Simulated time advanced per tick, the caller must hold w.mu
+func (w *World) timeStep() float64 {
+	return TimeStep * w.timeScale
+}
+
+// Advance the world's physics one step: pairwise gravity, motion, and
+// collisions. The main world's tick, runTick, runs the same stages with the
+// game's in between. The caller must hold w.mu.
+func (w *World) advance() {
+	w.tick++
+	all := w.bodies()
+	w.accumulateNBody(all)
+	for _, entity := range all {
+		w.integrate(entity)
+	}
+	w.resolveCollisions(all)
+}
+
+// Call tick at the physics rate until stop, checked after every tick,
+// reports true. The ticker drops ticks for a slow consumer, so the gap is
+// counted explicitly, and with catch-up on the missed steps are replayed.
+// Both callbacks run without w.mu held.
+func (w *World) loop(tick func(now time.Time, catchUp bool), stop func(now time.Time) bool) {
+	if config.LockPhysicsThread {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+	period := time.Second / TickRate
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	var last time.Time
+
+	for now := range ticker.C {
+		if !last.IsZero() {
+			if missed := int(now.Sub(last)/period) - 1; missed > 0 {
+				w.missed.Add(uint64(missed))
+				log.Println("Missed", missed, "ticks")
+				if config.CatchUp {
+					for i := 0; i < min(missed, MaxCatchUpTicks); i++ {
+						tick(now, true)
+					}
+				}
+			}
+		}
+		last = now
+		tick(now, false)
+		if stop(now) {
+			return
+		}
+	}
+}
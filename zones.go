@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Most refuel zones supported, one bit of entity state each
+const MaxRefuelZones = 64
+
+// RefuelZone is a circle where entities regenerate fuel faster
+type RefuelZone struct {
+	Center Vector2 `json:"center"`
+	Radius float64 `json:"radius"`
+	Rate   float64 `json:"rate"` // Extra fuel per second while inside
+}
+
+// ZoneEvent is sent when an entity enters or leaves a refuel zone
+type ZoneEvent struct {
+	Envelope
+	ID   string `json:"id"`
+	Zone int    `json:"zone"` // Index into the configured zones
+}
+
+// refuelZones is a repeatable flag.Value holding zones given as x,y,radius,rate
+type refuelZones []RefuelZone
+
+func (z *refuelZones) String() string {
+	parts := make([]string, len(*z))
+	for i, zone := range *z {
+		parts[i] = fmt.Sprintf("%g,%g,%g,%g", zone.Center.X, zone.Center.Y, zone.Radius, zone.Rate)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (z *refuelZones) Set(s string) error {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return fmt.Errorf("zone %q is not x,y,radius,rate", s)
+	}
+	var v [4]float64
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || !isFinite(f) {
+			return fmt.Errorf("zone %q has an invalid number", s)
+		}
+		v[i] = f
+	}
+	if v[2] <= 0 || v[3] < 0 {
+		return fmt.Errorf("zone %q needs a positive radius and non-negative rate", s)
+	}
+	if len(*z) == MaxRefuelZones {
+		return fmt.Errorf("at most %d refuel zones", MaxRefuelZones)
+	}
+	*z = append(*z, RefuelZone{Center: Vector2{X: v[0], Y: v[1]}, Radius: v[2], Rate: v[3]})
+	return nil
+}
+
+// Refuel entities inside zones and report zone entries and exits, the caller
+// must hold clientsMu
+func updateZones(all []*Entity) {
+	for _, entity := range all {
+		if !entity.alive() {
+			continue
+		}
+		for i, zone := range config.RefuelZones {
+			d := displacement(zone.Center, entity.Position)
+			inside := math.Hypot(d.X, d.Y) < zone.Radius
+			bit := uint64(1) << i
+			was := entity.zones&bit != 0
+			switch {
+			case inside && !was:
+				entity.zones |= bit
+				emitEvent(ZoneEvent{Envelope: envelope("zone_enter"), ID: entity.ID, Zone: i})
+			case !inside && was:
+				entity.zones &^= bit
+				emitEvent(ZoneEvent{Envelope: envelope("zone_leave"), ID: entity.ID, Zone: i})
+			}
+			if inside && config.FuelCapacity > 0 {
+				entity.Fuel = math.Min(config.FuelCapacity, entity.Fuel+zone.Rate*world.timeStep())
+			}
+		}
+	}
+}